@@ -0,0 +1,184 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache
+// Parse in concurrency6.go runs the expensive parser for every call, even when
+// the input was parsed a moment ago. Cache wraps any func(K) (V, error):
+//   - a hit returns the stored value, a miss calls the function through a
+//     singleflight Group, so concurrent misses of one key share one call
+//   - the entries are kept in an LRU list, when the total size goes over MaxBytes
+//     the least recently used ones are evicted
+//   - an entry older than TTL is a miss
+//   - errors are not cached, the next call tries again
+//
+// The size of an entry is whatever Size says, the cache can't know how many
+// bytes a V uses
+
+type Options[K comparable, V any] struct {
+	MaxBytes int64                    // 0 means no limit
+	Size     func(key K, val V) int64 // nil counts every entry as 1 byte
+	TTL      time.Duration            // 0 means entries don't expire
+	Now      func() time.Time         // clock, time.Now when nil
+}
+
+type Stats struct {
+	Hits      int64
+	Misses    int64 // calls that had to wait for the function
+	Shared    int64 // misses served by another caller's call
+	Loads     int64 // times the function actually ran
+	Errors    int64
+	Evictions int64 // removed to make room
+	Expired   int64
+	Entries   int
+	Bytes     int64
+}
+
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type entry[K comparable, V any] struct {
+	key      K
+	val      V
+	size     int64
+	storedAt time.Time
+}
+
+type Cache[K comparable, V any] struct {
+	load  func(K) (V, error)
+	opts  Options[K, V]
+	group Group[K, V]
+
+	l     sync.Mutex
+	ll    *list.List // front is the most recently used
+	items map[K]*list.Element
+	bytes int64
+	stats Stats
+}
+
+func New[K comparable, V any](load func(K) (V, error), opts Options[K, V]) *Cache[K, V] {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Size == nil {
+		opts.Size = func(K, V) int64 { return 1 }
+	}
+	return &Cache[K, V]{
+		load:  load,
+		opts:  opts,
+		ll:    list.New(),
+		items: map[K]*list.Element{},
+	}
+}
+
+// Wrap returns a function with the same signature as fn that goes through a
+// cache, callers don't even notice
+func Wrap[K comparable, V any](fn func(K) (V, error), opts Options[K, V]) (func(K) (V, error), *Cache[K, V]) {
+	c := New(fn, opts)
+	return c.Get, c
+}
+
+func (c *Cache[K, V]) Get(key K) (V, error) {
+	if val, ok := c.lookup(key); ok {
+		return val, nil
+	}
+	val, err, shared := c.group.Do(key, func() (V, error) {
+		// another flight may have stored it between our lookup and Do
+		if val, ok := c.peek(key); ok {
+			return val, nil
+		}
+		val, err := c.load(key)
+		c.l.Lock()
+		c.stats.Loads++
+		if err != nil {
+			c.stats.Errors++
+		}
+		c.l.Unlock()
+		if err == nil {
+			c.store(key, val)
+		}
+		return val, err
+	})
+	if shared {
+		c.l.Lock()
+		c.stats.Shared++
+		c.l.Unlock()
+	}
+	return val, err
+}
+
+// lookup counts a hit or a miss and moves the entry to the front
+func (c *Cache[K, V]) lookup(key K) (V, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.items[key]; ok {
+		ent := e.Value.(*entry[K, V])
+		if c.opts.TTL <= 0 || c.opts.Now().Sub(ent.storedAt) < c.opts.TTL {
+			c.ll.MoveToFront(e)
+			c.stats.Hits++
+			return ent.val, true
+		}
+		c.removeLocked(e)
+		c.stats.Expired++
+	}
+	c.stats.Misses++
+	var zero V
+	return zero, false
+}
+
+// peek is lookup without touching the statistics
+func (c *Cache[K, V]) peek(key K) (V, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.items[key]; ok {
+		ent := e.Value.(*entry[K, V])
+		if c.opts.TTL <= 0 || c.opts.Now().Sub(ent.storedAt) < c.opts.TTL {
+			return ent.val, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *Cache[K, V]) store(key K, val V) {
+	size := c.opts.Size(key, val)
+	c.l.Lock()
+	defer c.l.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeLocked(e)
+	}
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		// it would evict everything and still not fit
+		return
+	}
+	e := c.ll.PushFront(&entry[K, V]{key: key, val: val, size: size, storedAt: c.opts.Now()})
+	c.items[key] = e
+	c.bytes += size
+	for c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes {
+		c.removeLocked(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache[K, V]) removeLocked(e *list.Element) {
+	ent := c.ll.Remove(e).(*entry[K, V])
+	delete(c.items, ent.key)
+	c.bytes -= ent.size
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	s := c.stats
+	s.Entries = c.ll.Len()
+	s.Bytes = c.bytes
+	return s
+}
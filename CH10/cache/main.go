@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fakeClock lets the demo expire entries without waiting
+type fakeClock struct {
+	l sync.Mutex
+	t time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+func main() {
+	var parses atomic.Int32
+	// the expensive parser of concurrency6.go
+	slowParse := func(s string) (string, error) {
+		parses.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return strings.ToUpper(s), nil
+	}
+
+	clock := &fakeClock{t: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)}
+	Parse, cache := Wrap(slowParse, Options[string, string]{
+		// the bytes of key and value, good enough for strings
+		Size: func(k, v string) int64 {
+			return int64(len(k) + len(v))
+		},
+		MaxBytes: 40,
+		TTL:      time.Minute,
+		Now:      clock.Now,
+	})
+
+	// 20 goroutines ask for the same input at once, the parser runs once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Parse("hello")
+		}()
+	}
+	wg.Wait()
+	fmt.Println("20 concurrent calls, parses:", parses.Load())
+
+	for i := 0; i < 5; i++ {
+		Parse("hello")
+	}
+	s := cache.Stats()
+	fmt.Printf("hits=%d misses=%d shared=%d loads=%d ratio=%.2f\n",
+		s.Hits, s.Misses, s.Shared, s.Loads, s.HitRatio())
+
+	// every entry of 10 bytes, 40 fit, the fifth one evicts "hello", the least
+	// recently used
+	for _, in := range []string{"abcde", "fghij", "klmno", "pqrst"} {
+		Parse(in)
+	}
+	s = cache.Stats()
+	fmt.Printf("entries=%d bytes=%d evictions=%d\n", s.Entries, s.Bytes, s.Evictions)
+
+	clock.Advance(2 * time.Minute)
+	Parse("pqrst")
+	s = cache.Stats()
+	fmt.Printf("after the TTL: expired=%d loads=%d\n", s.Expired, s.Loads)
+}
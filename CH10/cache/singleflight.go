@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// Singleflight
+// When ten goroutines ask for the same key at the same time and it is not in the
+// cache, all of them would run the slow function. Group lets the first one run
+// it and the other nine wait for its result, one computation per key at a time
+
+type flight[V any] struct {
+	wg  sync.WaitGroup
+	val V
+	err error
+}
+
+type Group[K comparable, V any] struct {
+	l       sync.Mutex
+	flights map[K]*flight[V]
+}
+
+// Do runs fn for key unless a call for the same key is already running, then it
+// waits for that one. shared tells if the result came from another caller's call
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (val V, err error, shared bool) {
+	g.l.Lock()
+	if g.flights == nil {
+		g.flights = map[K]*flight[V]{}
+	}
+	if f, ok := g.flights[key]; ok {
+		g.l.Unlock()
+		f.wg.Wait()
+		return f.val, f.err, true
+	}
+	f := &flight[V]{}
+	f.wg.Add(1)
+	g.flights[key] = f
+	g.l.Unlock()
+
+	// the deferred cleanup also runs if fn panics, the waiters are not stuck and
+	// get a PanicError instead of a zero value that looks like a good load, the
+	// caller that ran fn panics again like fn did
+	normal := false
+	defer func() {
+		var r any
+		if !normal {
+			// a panic, or runtime.Goexit, then r is nil
+			r = recover()
+			f.err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		g.l.Lock()
+		delete(g.flights, key)
+		g.l.Unlock()
+		f.wg.Done()
+		if r != nil {
+			panic(r)
+		}
+	}()
+	f.val, f.err = fn()
+	normal = true
+	return f.val, f.err, false
+}
+
+// PanicError is what the waiters get when the function they waited for panicked
+type PanicError struct {
+	Value any // nil if fn called runtime.Goexit
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Value == nil {
+		return "singleflight: the function exited without returning"
+	}
+	return fmt.Sprintf("singleflight: the function panicked: %v", e.Value)
+}
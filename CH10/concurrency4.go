@@ -20,11 +20,12 @@ func countTo(max int) (<-chan int, func()) {
 	}
 	go func() {
 		for i := 0; i < max; i++ {
+			// The send is a case of the select. If it were in a default branch,
+			// a canceled generator could block on ch <- i forever
 			select {
 			case <-done:
 				return
-			default:
-				ch <- i
+			case ch <- i:
 			}
 		}
 		close(ch)
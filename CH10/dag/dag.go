@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dependency graphs
+// GatherAndProcess in concurrency7.go knows that A and B run in parallel and C
+// waits for both, the processor has a channel for every step and waitForAB and
+// waitForC are written for that exact shape. Graph is the general version: every
+// task says which tasks it needs, and Run starts each one as soon as its
+// dependencies are done. The rest is the same as in GatherAndProcess:
+//   - one context for the whole graph, its deadline is the deadline of all tasks
+//   - the first error cancels the context, every running task sees it and the
+//     tasks that didn't start never will
+//
+// The outputs are typed: Add returns a Task[T] handle, and Value(r, task) gives
+// back a T, no interface{} conversions in the task code. A task can only depend
+// on handles that already exist, so a graph can't have cycles
+
+type Graph struct {
+	nodes []*node
+}
+
+type node struct {
+	graph *Graph
+	name  string
+	deps  []*node
+	run   func(context.Context, *Results) (any, error)
+	done  chan struct{} // closed when the task succeeded
+	val   any
+}
+
+// Dep is anything Add accepts as a dependency, every Task[T]
+type Dep interface {
+	node() *node
+}
+
+type Task[T any] struct {
+	n *node
+}
+
+func (t Task[T]) node() *node {
+	return t.n
+}
+
+func (t Task[T]) Name() string {
+	return t.n.name
+}
+
+// Add declares a task, fn receives the outputs of deps
+func Add[T any](g *Graph, name string, fn func(context.Context, *Results) (T, error), deps ...Dep) Task[T] {
+	n := &node{
+		graph: g,
+		name:  name,
+		run: func(ctx context.Context, r *Results) (any, error) {
+			return fn(ctx, r)
+		},
+	}
+	for _, d := range deps {
+		dn := d.node()
+		if dn.graph != g {
+			panic(fmt.Sprintf("dag: task %q depends on %q from another graph", name, dn.name))
+		}
+		n.deps = append(n.deps, dn)
+	}
+	g.nodes = append(g.nodes, n)
+	return Task[T]{n: n}
+}
+
+// Results holds the outputs of finished tasks, a task sees the ones of its
+// dependencies and Run returns all of them
+type Results struct {
+	vals map[*node]any
+}
+
+// Value panics when the task is not in r, asking for a task that was not declared
+// as a dependency is a bug in the graph, not an error at runtime
+func Value[T any](r *Results, t Task[T]) T {
+	v, ok := r.vals[t.n]
+	if !ok {
+		panic(fmt.Sprintf("dag: no result for %q, is it a dependency?", t.n.name))
+	}
+	return v.(T)
+}
+
+// Span is one line of the trace, times are relative to the start of Run
+type Span struct {
+	Task  string
+	Start time.Duration
+	End   time.Duration
+	Err   error
+}
+
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (te TaskError) Error() string {
+	return fmt.Sprintf("task %s: %v", te.Task, te.Err)
+}
+
+func (te TaskError) Unwrap() error {
+	return te.Err
+}
+
+// Run executes the graph, it returns the first error, wrapped in a TaskError, and
+// the trace of the tasks that started, in start order. A graph can be run once
+func (g *Graph) Run(ctx context.Context) (*Results, []Span, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	begin := time.Now()
+	for _, n := range g.nodes {
+		n.done = make(chan struct{})
+	}
+
+	var l sync.Mutex
+	var trace []Span
+	var firstErr error
+	fail := func(err error) {
+		l.Lock()
+		defer l.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(g.nodes))
+	for _, n := range g.nodes {
+		go func() {
+			defer wg.Done()
+			deps := &Results{vals: map[*node]any{}}
+			for _, d := range n.deps {
+				select {
+				case <-d.done:
+					// val was written before done was closed, safe to read
+					deps.vals[d] = d.val
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			start := time.Since(begin)
+			val, err := n.run(ctx, deps)
+			span := Span{Task: n.name, Start: start, End: time.Since(begin), Err: err}
+			l.Lock()
+			trace = append(trace, span)
+			l.Unlock()
+			if err != nil {
+				fail(TaskError{Task: n.name, Err: err})
+				return
+			}
+			n.val = val
+			close(n.done)
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		// the parent context ended, no task failed on its own
+		firstErr = context.Cause(ctx)
+	}
+	all := &Results{vals: map[*node]any{}}
+	for _, n := range g.nodes {
+		select {
+		case <-n.done:
+			all.vals[n] = n.val
+		default:
+		}
+	}
+	sort.Slice(trace, func(i, j int) bool {
+		return trace[i].Start < trace[j].Start
+	})
+	return all, trace, firstErr
+}
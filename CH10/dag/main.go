@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The services of GatherAndProcess, each one takes a while and honors ctx
+func call(ctx context.Context, d time.Duration, fail bool, out string) (string, error) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if fail {
+		return "", errors.New("service unavailable")
+	}
+	return out, nil
+}
+
+// gatherAndProcess is GatherAndProcess as a graph, the shape is in the deps and
+// not in the code that waits
+func gatherAndProcess(ctx context.Context, failB bool) {
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	var g Graph
+	a := Add(&g, "A", func(ctx context.Context, _ *Results) (string, error) {
+		return call(ctx, 20*time.Millisecond, false, "a")
+	})
+	b := Add(&g, "B", func(ctx context.Context, _ *Results) (int, error) {
+		if _, err := call(ctx, 10*time.Millisecond, failB, ""); err != nil {
+			return 0, err
+		}
+		return 2, nil
+	})
+	c := Add(&g, "C", func(ctx context.Context, r *Results) (string, error) {
+		return call(ctx, 15*time.Millisecond, false, strings.Repeat(Value(r, a), Value(r, b)))
+	}, a, b)
+
+	results, trace, err := g.Run(ctx)
+	for _, s := range trace {
+		fmt.Printf("  %-2s %3dms -> %3dms  err=%v\n", s.Task,
+			s.Start.Milliseconds(), s.End.Milliseconds(), s.Err)
+	}
+	if err != nil {
+		var te TaskError
+		errors.As(err, &te)
+		fmt.Println("  failed in", te.Task+":", err)
+		return
+	}
+	fmt.Println("  C =", Value(results, c))
+}
+
+func main() {
+	fmt.Println("all good, A and B in parallel, C after both:")
+	gatherAndProcess(context.Background(), false)
+	fmt.Println("B fails, A is canceled and C never starts:")
+	gatherAndProcess(context.Background(), true)
+}
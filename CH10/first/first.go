@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// First success wins
+// searchData in concurrency3.go sends the query to every searcher and keeps the
+// first answer, but an error from a searcher would win like any other answer, the
+// losers keep working until they finish and there is no way to give up after a
+// while. First fixes the three things:
+//   - a failed function doesn't win, First waits for the next one
+//   - the losers run with a context that is canceled as soon as there is a winner
+//   - the caller's context bounds the whole thing, a deadline in it is a timeout
+//
+// When every function fails, the error has all of them, errors.Is and errors.As
+// look inside it
+
+// First runs every fn at once and returns the first success
+func First[T any](ctx context.Context, fns ...func(context.Context) (T, error)) (T, error) {
+	return race(ctx, 0, fns)
+}
+
+// Hedged runs fn once, and only if there is no answer after delay it starts a
+// second attempt (and so on, up to attempts). A slow attempt is not canceled,
+// whichever finishes first wins. Most calls pay for one request, the slow ones
+// get a second chance instead of waiting for the tail latency. A failed attempt
+// starts the next one right away
+func Hedged[T any](ctx context.Context, delay time.Duration, attempts int, fn func(context.Context) (T, error)) (T, error) {
+	fns := make([]func(context.Context) (T, error), attempts)
+	for i := range fns {
+		fns[i] = fn
+	}
+	return race(ctx, delay, fns)
+}
+
+type attempt[T any] struct {
+	index int
+	val   T
+	err   error
+}
+
+// race starts fns[0], and every delay one more, delay 0 starts them all together
+func race[T any](ctx context.Context, delay time.Duration, fns []func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(fns) == 0 {
+		return zero, errors.New("first: no functions to run")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// the winner is returned, cancel tells the losers to stop
+	defer cancel()
+	// buffered, a loser can write its result and exit even if nobody reads it
+	results := make(chan attempt[T], len(fns))
+	launched := 0
+	launch := func() {
+		i := launched
+		launched++
+		go func() {
+			val, err := fns[i](ctx)
+			results <- attempt[T]{index: i, val: val, err: err}
+		}()
+	}
+
+	// hedge fires when it is time for the next attempt, it stays nil (a case that
+	// never runs) when there is no delay or nothing left to launch
+	var hedge <-chan time.Time
+	var timer *time.Timer
+	schedule := func() {
+		hedge = nil
+		if timer != nil && launched < len(fns) {
+			timer.Reset(delay)
+			hedge = timer.C
+		}
+	}
+	if delay > 0 {
+		timer = time.NewTimer(delay)
+		defer timer.Stop()
+		launch()
+		schedule()
+	} else {
+		for launched < len(fns) {
+			launch()
+		}
+	}
+
+	var errs []error
+	for {
+		select {
+		case r := <-results:
+			if r.err == nil {
+				return r.val, nil
+			}
+			errs = append(errs, fmt.Errorf("attempt %d: %w", r.index, r.err))
+			if len(errs) == len(fns) {
+				return zero, errors.Join(errs...)
+			}
+			if launched < len(fns) {
+				launch()
+				schedule()
+			}
+		case <-hedge:
+			launch()
+			schedule()
+		case <-ctx.Done():
+			return zero, errors.Join(append([]error{context.Cause(ctx)}, errs...)...)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// searcher answers after delay, or fails, and stops when its context is canceled
+func searcher(name string, delay time.Duration, fail bool, canceled *atomic.Int32) func(context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			canceled.Add(1)
+			return nil, ctx.Err()
+		}
+		if fail {
+			return nil, fmt.Errorf("%s is down", name)
+		}
+		return []string{name + ": result"}, nil
+	}
+}
+
+func main() {
+	ctx := context.Background()
+	var canceled atomic.Int32
+
+	// the fastest one fails, the next one wins and the slow one is canceled
+	r, err := First(ctx,
+		searcher("fast", 5*time.Millisecond, true, &canceled),
+		searcher("medium", 20*time.Millisecond, false, &canceled),
+		searcher("slow", time.Second, false, &canceled),
+	)
+	time.Sleep(10 * time.Millisecond)
+	fmt.Println("first:", r, err, "- losers canceled:", canceled.Load())
+
+	_, err = First(ctx,
+		searcher("a", time.Millisecond, true, &canceled),
+		searcher("b", 2*time.Millisecond, true, &canceled),
+	)
+	fmt.Println("all failed:")
+	fmt.Println(err)
+
+	// the deadline is the timeout
+	tctx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	_, err = First(tctx, searcher("slow", time.Second, false, &canceled))
+	cancel()
+	fmt.Println("timeout:", errors.Is(err, context.DeadlineExceeded))
+
+	// Hedging: most calls are fast, one in three takes 200ms. With a hedge after
+	// 20ms the slow ones cost about 20ms plus a fast attempt
+	var calls atomic.Int32
+	backend := func(ctx context.Context) (string, error) {
+		n := calls.Add(1)
+		d := 5 * time.Millisecond
+		if n%3 == 1 {
+			d = 200 * time.Millisecond
+		}
+		select {
+		case <-time.After(d):
+			return fmt.Sprintf("answer from call %d", n), nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	start := time.Now()
+	var answers []string
+	for i := 0; i < 3; i++ {
+		a, err := Hedged(ctx, 20*time.Millisecond, 2, backend)
+		if err != nil {
+			fmt.Println(err)
+		}
+		answers = append(answers, a)
+	}
+	fmt.Printf("hedged: %s in %v with %d calls\n", strings.Join(answers, ", "),
+		time.Since(start).Round(10*time.Millisecond), calls.Load())
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Generators that don't leak
+// countTo in concurrency3.go blocks forever if the reader stops early, the one in
+// concurrency4.go adds a cancel func, but it checks done in a select with a
+// default branch, the send happens outside the select, so a canceled generator
+// can still be stuck on ch <- i. The fix is to put the send itself in the select:
+//
+//	select {
+//	case ch <- v:
+//	case <-ctx.Done():
+//		return
+//	}
+//
+// Every helper in this file does that for every send and every receive, so once
+// ctx is canceled all of their goroutines exit, whatever the readers are doing.
+// The rule for the caller: always cancel the context when you stop reading,
+// usually with defer cancel()
+
+// send is the select above, false means ctx is done and the goroutine must return
+func send[T any](ctx context.Context, ch chan<- T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// receive is the same for reads, ok is false when ctx is done or in is closed
+func receive[T any](ctx context.Context, in <-chan T) (T, bool) {
+	select {
+	case v, ok := <-in:
+		return v, ok
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
+}
+
+// Generate runs f in a goroutine, every value f passes to yield is written to the
+// channel. yield returns false when ctx is done, then f must return
+func Generate[T any](ctx context.Context, f func(yield func(T) bool)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		f(func(v T) bool {
+			return send(ctx, out, v)
+		})
+	}()
+	return out
+}
+
+// CountTo is countTo written with Generate
+func CountTo(ctx context.Context, max int) <-chan int {
+	return Generate(ctx, func(yield func(int) bool) {
+		for i := 0; i < max; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	})
+}
+
+// Take closes its channel after n values, the values left in `in` are not read,
+// cancel ctx to stop whoever produces them
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			if keep(v) && !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func Map[T, R any](ctx context.Context, in <-chan T, f func(T) R) <-chan R {
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok || !send(ctx, out, f(v)) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Batch groups the values in slices of size, the last one can be shorter
+func Batch[T any](ctx context.Context, in <-chan T, size int) <-chan []T {
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var batch []T
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				break
+			}
+			batch = append(batch, v)
+			if len(batch) == size {
+				if !send(ctx, out, batch) {
+					return
+				}
+				batch = nil
+			}
+		}
+		if len(batch) > 0 && ctx.Err() == nil {
+			send(ctx, out, batch)
+		}
+	}()
+	return out
+}
+
+// Tee writes every value to both channels, the slower reader sets the pace, so
+// both of them have to be read (or ctx canceled)
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	out1 := make(chan T)
+	out2 := make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for {
+			v, ok := receive(ctx, in)
+			if !ok {
+				return
+			}
+			// the nil channel trick from concurrency5.go: once a copy was sent,
+			// that case is turned off and the select waits for the other one
+			o1, o2 := out1, out2
+			for o1 != nil || o2 != nil {
+				select {
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// FanIn merges the channels in one, the output is closed when all of them are
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				v, ok := receive(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}(in)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// FanOut spreads the values of in over n channels, each value goes to only one
+// of them, the one whose reader is ready first
+func FanOut[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	outs := make([]<-chan T, n)
+	for i := range outs {
+		out := make(chan T)
+		outs[i] = out
+		go func() {
+			defer close(out)
+			for {
+				v, ok := receive(ctx, in)
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+	return outs
+}
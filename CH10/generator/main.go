@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Every check stops reading early on purpose, then the goroutine count must go
+// back to where it was. That is the leak test: a helper that forgets a select on
+// ctx.Done() leaves a goroutine stuck and the count stays up
+var failed bool
+
+func checkLeaks(name string, f func()) {
+	before := runtime.NumGoroutine()
+	f()
+	// a goroutine that saw ctx.Done() still has to be scheduled to return
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - before; leaked > 0 {
+		failed = true
+		fmt.Printf("FAIL %s: %d goroutines leaked\n", name, leaked)
+		return
+	}
+	fmt.Printf("ok   %s\n", name)
+}
+
+// forever never ends on its own, only the context stops it
+func forever(ctx context.Context) <-chan int {
+	return Generate(ctx, func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	})
+}
+
+func main() {
+	checkLeaks("countTo, break early", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for i := range CountTo(ctx, 10) {
+			if i > 5 {
+				break
+			}
+		}
+	})
+
+	checkLeaks("pipeline", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		even := Filter(ctx, forever(ctx), func(v int) bool { return v%2 == 0 })
+		squares := Map(ctx, even, func(v int) int { return v * v })
+		for b := range Batch(ctx, Take(ctx, squares, 7), 3) {
+			fmt.Println("     batch", b)
+		}
+	})
+
+	checkLeaks("tee, one reader stops", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		a, b := Tee(ctx, forever(ctx))
+		go func() {
+			for range b {
+			}
+		}()
+		<-a
+		<-a
+	})
+
+	checkLeaks("fan out and fan in", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		outs := FanOut(ctx, forever(ctx), 4)
+		sum := 0
+		for v := range Take(ctx, FanIn(ctx, outs...), 100) {
+			sum += v
+		}
+		fmt.Println("     sum of 100 values", sum)
+	})
+
+	checkLeaks("seq pipeline, no goroutines", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		odd := FilterSeq(CountToSeq(ctx, 1000), func(v int) bool { return v%2 == 1 })
+		for b := range BatchSeq(TakeSeq(MapSeq(odd, func(v int) int { return v * 10 }), 5), 2) {
+			fmt.Println("     batch", b)
+		}
+	})
+
+	checkLeaks("seq from a channel", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for v := range Seq(ctx, forever(ctx)) {
+			if v == 3 {
+				break
+			}
+		}
+	})
+
+	// The Seq versions of the concurrent helpers clean up when their loops end,
+	// even if the parent context is never canceled
+	checkLeaks("tee seq", func() {
+		a, b, _ := TeeSeq(context.Background(), CountToSeq(context.Background(), 1000))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range b {
+				if v == 10 {
+					break
+				}
+			}
+		}()
+		count := 0
+		for range a {
+			count++
+		}
+		wg.Wait()
+		fmt.Println("     first reader got", count)
+	})
+
+	// a Seq that is never ranged over can't clean up, stop does it. Here the
+	// caller changed its mind before starting the loops
+	checkLeaks("tee seq, never ranged", func() {
+		_, _, stop := TeeSeq(context.Background(), CountToSeq(context.Background(), 1000))
+		stop()
+	})
+
+	checkLeaks("fan in seq, break early", func() {
+		ctx := context.Background()
+		for v := range FanInSeq(ctx, CountToSeq(ctx, 1000), CountToSeq(ctx, 1000)) {
+			if v == 500 {
+				break
+			}
+		}
+	})
+
+	checkLeaks("fan out seq", func() {
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		var l sync.Mutex
+		total := 0
+		seqs, _ := FanOutSeq(ctx, CountToSeq(ctx, 1000), 3)
+		for i, seq := range seqs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for v := range seq {
+					if i == 0 && v > 100 {
+						break // the others finish the work
+					}
+					l.Lock()
+					total++
+					l.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		fmt.Println("     values processed", total)
+	})
+
+	checkLeaks("fan out seq, never ranged", func() {
+		ctx := context.Background()
+		seqs, stop := FanOutSeq(ctx, CountToSeq(ctx, 1000), 3)
+		defer stop()
+		for v := range seqs[0] {
+			if v > 100 {
+				break
+			}
+		}
+	})
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// iter.Seq adapters
+// Since Go 1.23 a for-range can loop over a function, iter.Seq[T] is a
+// func(yield func(T) bool), the loop body is yield and a break makes it return
+// false. The linear helpers don't need goroutines at all in that form, the loop
+// itself drives the work and a break stops it, nothing is left running.
+// Tee, FanIn and FanOut are concurrent by nature, their Seq versions use the
+// channel ones with a context of their own, canceled when the loops are over.
+// TeeSeq and FanOutSeq return more than one Seq and a Seq that is never ranged
+// over never ends its loop, so they also return a stop func, like countTo in
+// concurrency4.go returns its cancel func
+
+// Seq reads ch until it is closed or ctx is done
+func Seq[T any](ctx context.Context, ch <-chan T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			v, ok := receive(ctx, ch)
+			if !ok || !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Chan runs seq in a goroutine and writes its values to a channel, an iter.Seq
+// is exactly what Generate expects
+func Chan[T any](ctx context.Context, seq iter.Seq[T]) <-chan T {
+	return Generate(ctx, seq)
+}
+
+// GenerateSeq stops asking f for values once ctx is done
+func GenerateSeq[T any](ctx context.Context, f func(yield func(T) bool)) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		f(func(v T) bool {
+			return ctx.Err() == nil && yield(v)
+		})
+	}
+}
+
+func CountToSeq(ctx context.Context, max int) iter.Seq[int] {
+	return GenerateSeq(ctx, func(yield func(int) bool) {
+		for i := 0; i < max; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	})
+}
+
+func TakeSeq[T any](seq iter.Seq[T], n int) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		if n <= 0 {
+			return
+		}
+		i := 0
+		for v := range seq {
+			if !yield(v) {
+				return
+			}
+			i++
+			if i == n {
+				return
+			}
+		}
+	}
+}
+
+func FilterSeq[T any](seq iter.Seq[T], keep func(T) bool) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range seq {
+			if keep(v) && !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+func MapSeq[T, R any](seq iter.Seq[T], f func(T) R) iter.Seq[R] {
+	return func(yield func(R) bool) {
+		for v := range seq {
+			if !yield(f(v)) {
+				return
+			}
+		}
+	}
+}
+
+func BatchSeq[T any](seq iter.Seq[T], size int) iter.Seq[[]T] {
+	return func(yield func([]T) bool) {
+		var batch []T
+		for v := range seq {
+			batch = append(batch, v)
+			if len(batch) == size {
+				if !yield(batch) {
+					return
+				}
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			yield(batch)
+		}
+	}
+}
+
+// seqs wraps the outputs of a concurrent stage, when the last loop is over the
+// stage context is canceled and its goroutines exit. With drain, a loop that ends
+// early keeps reading its channel in the background, Tee waits for both readers
+// and would stall the other loop. Each Seq can be ranged over only once
+func seqs[T any](ctx context.Context, cancel context.CancelFunc, chans []<-chan T, drain bool) []iter.Seq[T] {
+	var left atomic.Int32
+	left.Store(int32(len(chans)))
+	out := make([]iter.Seq[T], len(chans))
+	for i, ch := range chans {
+		var once sync.Once
+		out[i] = func(yield func(T) bool) {
+			defer once.Do(func() {
+				if left.Add(-1) == 0 {
+					cancel()
+				} else if drain {
+					go func() {
+						for range ch {
+						}
+					}()
+				}
+			})
+			for v := range Seq(ctx, ch) {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+	return out
+}
+
+// TeeSeq, both loops must run at the same time (in different goroutines), each
+// value is handed to both of them. stop ends the stage goroutines, it can be
+// skipped only if both Seqs are ranged over, defer it to be sure
+func TeeSeq[T any](ctx context.Context, seq iter.Seq[T]) (iter.Seq[T], iter.Seq[T], func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	a, b := Tee(ctx, Chan(ctx, seq))
+	s := seqs(ctx, cancel, []<-chan T{a, b}, true)
+	return s[0], s[1], cancel
+}
+
+func FanInSeq[T any](ctx context.Context, in ...iter.Seq[T]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		chans := make([]<-chan T, len(in))
+		for i, seq := range in {
+			chans[i] = Chan(ctx, seq)
+		}
+		for v := range Seq(ctx, FanIn(ctx, chans...)) {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// FanOutSeq, stop works like the one of TeeSeq
+func FanOutSeq[T any](ctx context.Context, seq iter.Seq[T], n int) ([]iter.Seq[T], func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	return seqs(ctx, cancel, FanOut(ctx, Chan(ctx, seq), n), false), cancel
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Structured concurrency
+// ex1 in concurrency6.go launches three goroutines with a WaitGroup, if one of
+// them fails nobody knows and the others keep working for nothing. Group is a
+// WaitGroup that also carries:
+//   - a context shared by every goroutine, canceled by the first error
+//   - the errors, Wait returns the first one, or all of them joined
+//   - an optional limit of goroutines running at the same time
+//
+// Like a WaitGroup, the zero value is ready to use (with context.Background),
+// and it must not be copied after first use
+
+type Group struct {
+	init   sync.Once
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+	sem    chan struct{} // nil means no limit
+
+	l    sync.Mutex
+	join bool
+	errs []error
+}
+
+// WithContext returns a Group whose context is derived from ctx
+func WithContext(ctx context.Context) *Group {
+	g := &Group{}
+	g.init.Do(func() {
+		g.ctx, g.cancel = context.WithCancelCause(ctx)
+	})
+	return g
+}
+
+func (g *Group) lazyInit() {
+	g.init.Do(func() {
+		g.ctx, g.cancel = context.WithCancelCause(context.Background())
+	})
+}
+
+// SetLimit allows at most n goroutines at the same time, Go blocks until one of
+// them finishes and TryGo gives up. A negative n removes the limit. It can't be
+// changed while goroutines are running
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("group: SetLimit with %d goroutines still running", len(g.sem)))
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// SetJoinErrors makes Wait return every error joined with errors.Join instead
+// of only the first one. The first error still cancels the context, so expect
+// context.Canceled among the others
+func (g *Group) SetJoinErrors(join bool) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.join = join
+}
+
+// Go runs f in a new goroutine, waiting for a free slot if there is a limit
+func (g *Group) Go(f func(ctx context.Context) error) {
+	g.lazyInit()
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.start(f)
+}
+
+// TryGo is Go without waiting, it returns false if the limit was reached and f
+// was not started
+func (g *Group) TryGo(f func(ctx context.Context) error) bool {
+	g.lazyInit()
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	g.start(f)
+	return true
+}
+
+func (g *Group) start(f func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+		if err := f(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+func (g *Group) fail(err error) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	if len(g.errs) == 0 {
+		g.cancel(err)
+	}
+	if len(g.errs) == 0 || g.join {
+		g.errs = append(g.errs, err)
+	}
+}
+
+// Wait blocks until every goroutine returned, cancels the context and returns
+// the first error (or all of them, see SetJoinErrors)
+func (g *Group) Wait() error {
+	g.lazyInit()
+	g.wg.Wait()
+	g.cancel(nil)
+	g.l.Lock()
+	defer g.l.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	if g.join {
+		return errors.Join(g.errs...)
+	}
+	return g.errs[0]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// The three things from ex1, the second one fails and the others notice through
+// the context instead of running until the end
+func doThing(ctx context.Context) error {
+	select {
+	case <-time.After(time.Second):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("doThing stopped: %w", ctx.Err())
+	}
+}
+
+func doThing2(ctx context.Context) error {
+	time.Sleep(10 * time.Millisecond)
+	return errors.New("doThing2 failed")
+}
+
+func doThing3(ctx context.Context) error {
+	select {
+	case <-time.After(time.Second):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("doThing3 stopped: %w", context.Cause(ctx))
+	}
+}
+
+func main() {
+	start := time.Now()
+	var g Group
+	g.Go(doThing)
+	g.Go(doThing2)
+	g.Go(doThing3)
+	fmt.Println("first error:", g.Wait(), "after", time.Since(start).Round(10*time.Millisecond))
+
+	g2 := WithContext(context.Background())
+	g2.SetJoinErrors(true)
+	g2.Go(doThing)
+	g2.Go(doThing2)
+	g2.Go(doThing3)
+	fmt.Println("all errors:")
+	fmt.Println(g2.Wait())
+
+	// never more than 3 at the same time
+	var running, peak atomic.Int32
+	var g3 Group
+	g3.SetLimit(3)
+	for i := 0; i < 20; i++ {
+		g3.Go(func(ctx context.Context) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+	}
+	err := g3.Wait()
+	fmt.Println("limit 3, peak:", peak.Load(), "wait:", err)
+
+	var g4 Group
+	g4.SetLimit(1)
+	release := make(chan struct{})
+	fmt.Println("TryGo with a free slot:", g4.TryGo(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+	fmt.Println("TryGo with no slot:", g4.TryGo(func(ctx context.Context) error {
+		return nil
+	}))
+	close(release)
+	g4.Wait()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Lazy initialization that can fail
+// Parse in concurrency6.go builds the parser with a sync.Once, it is the right
+// tool when the initialization can't fail: once.Do runs the function one time,
+// ever. If initParser fails there is no error to return and no second try, every
+// later call uses a broken parser. Lazy[T] keeps the good part (the first callers
+// that arrive together share one initialization, the rest wait for it) and adds:
+//   - init returns an error, Get returns it, and the next Get tries again
+//   - an optional backoff between attempts, so a failing dependency isn't hit by
+//     every caller (until then Get returns the last error right away)
+//   - an optional TTL, an expired value is still returned while a refresh runs in
+//     the background, if the refresh fails the old value stays
+//   - Reset, to start from scratch in tests
+//
+// The zero value is not usable, create it with NewLazy
+
+type LazyOptions struct {
+	Backoff    time.Duration    // wait after the first failure, doubles after each one
+	MaxBackoff time.Duration    // upper limit for the backoff, 0 means no limit
+	TTL        time.Duration    // 0 means the value never expires
+	Now        func() time.Time // clock, time.Now when nil
+}
+
+// call is one run of init, the callers that wait for it read its result
+type call[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+type Lazy[T any] struct {
+	init func() (T, error)
+	opts LazyOptions
+
+	l        sync.Mutex
+	gen      int // incremented by Reset, a run from before a Reset is ignored
+	val      T
+	loaded   bool
+	loadedAt time.Time
+	err      error
+	failures int
+	retryAt  time.Time
+	running  *call[T] // nil when init is not running
+}
+
+func NewLazy[T any](init func() (T, error), opts LazyOptions) *Lazy[T] {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Lazy[T]{init: init, opts: opts}
+}
+
+func (lz *Lazy[T]) Get() (T, error) {
+	lz.l.Lock()
+	if lz.loaded {
+		val := lz.val
+		if lz.expiredLocked() {
+			// stale while revalidate, the caller doesn't wait for the refresh
+			lz.startLocked()
+		}
+		lz.l.Unlock()
+		return val, nil
+	}
+	if lz.running == nil && lz.err != nil && lz.opts.Now().Before(lz.retryAt) {
+		err := lz.err
+		lz.l.Unlock()
+		var zero T
+		return zero, err
+	}
+	c := lz.running
+	if c == nil {
+		c = lz.startLocked()
+	}
+	lz.l.Unlock()
+	<-c.done
+	return c.val, c.err
+}
+
+func (lz *Lazy[T]) expiredLocked() bool {
+	if lz.opts.TTL <= 0 || lz.running != nil {
+		return false
+	}
+	now := lz.opts.Now()
+	return !now.Before(lz.loadedAt.Add(lz.opts.TTL)) && !now.Before(lz.retryAt)
+}
+
+// startLocked launches init in a goroutine, the caller decides if it waits.
+// Nobody can recover a panic of that goroutine but itself, a panic in init would
+// end the process and the waiters would never be woken up. It becomes a
+// PanicError instead, a failed attempt like any other
+func (lz *Lazy[T]) startLocked() *call[T] {
+	c := &call[T]{done: make(chan struct{})}
+	lz.running = c
+	gen := lz.gen
+	go func() {
+		normal := false
+		defer func() {
+			if !normal {
+				// a panic, or runtime.Goexit, then recover returns nil
+				var zero T
+				c.val, c.err = zero, &PanicError{Value: recover(), Stack: debug.Stack()}
+			}
+			lz.finish(gen, c)
+			close(c.done)
+		}()
+		c.val, c.err = lz.init()
+		normal = true
+	}()
+	return c
+}
+
+// PanicError is what the callers get when init panicked
+type PanicError struct {
+	Value any // nil if init called runtime.Goexit
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Value == nil {
+		return "lazy: init exited without returning"
+	}
+	return fmt.Sprintf("lazy: init panicked: %v", e.Value)
+}
+
+func (lz *Lazy[T]) finish(gen int, c *call[T]) {
+	lz.l.Lock()
+	defer lz.l.Unlock()
+	if gen != lz.gen {
+		return
+	}
+	lz.running = nil
+	now := lz.opts.Now()
+	if c.err != nil {
+		lz.err = c.err
+		lz.failures++
+		lz.retryAt = now.Add(lz.backoffLocked())
+		return
+	}
+	lz.val, lz.loaded, lz.loadedAt = c.val, true, now
+	lz.err, lz.failures, lz.retryAt = nil, 0, time.Time{}
+}
+
+func (lz *Lazy[T]) backoffLocked() time.Duration {
+	limit := lz.opts.MaxBackoff
+	if limit <= 0 {
+		// no limit still has the limit of a Duration, doubling past it would
+		// overflow to a negative wait and the backoff would be gone
+		limit = math.MaxInt64
+	}
+	d := min(lz.opts.Backoff, limit)
+	for i := 1; i < lz.failures && d > 0; i++ {
+		if d > limit/2 {
+			return limit
+		}
+		d *= 2
+	}
+	return d
+}
+
+// Reset forgets the value and the errors, the next Get runs init again. A run
+// that is in progress finishes for the callers waiting on it, but its result is
+// not kept
+func (lz *Lazy[T]) Reset() {
+	lz.l.Lock()
+	defer lz.l.Unlock()
+	var zero T
+	lz.gen++
+	lz.val, lz.loaded, lz.loadedAt = zero, false, time.Time{}
+	lz.err, lz.failures, lz.retryAt = nil, 0, time.Time{}
+	lz.running = nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type SlowComplicatedParser interface {
+	Parse(string) string
+}
+
+type upperParser struct {
+	version int
+}
+
+func (up upperParser) Parse(s string) string {
+	return fmt.Sprintf("v%d:%s", up.version, strings.ToUpper(s))
+}
+
+// fakeClock lets the demo jump over the backoff and the TTL
+type fakeClock struct {
+	l sync.Mutex
+	t time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+func main() {
+	clock := &fakeClock{t: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)}
+	var calls atomic.Int32
+	// initParser fails the first time, like a config server that is not up yet
+	initParser := func() (SlowComplicatedParser, error) {
+		n := calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		if n == 1 {
+			return nil, errors.New("config server not ready")
+		}
+		return upperParser{version: int(n)}, nil
+	}
+	parser := NewLazy(initParser, LazyOptions{
+		Backoff: time.Second,
+		TTL:     time.Minute,
+		Now:     clock.Now,
+	})
+	Parse := func(s string) (string, error) {
+		p, err := parser.Get()
+		if err != nil {
+			return "", fmt.Errorf("in Parse: %w", err)
+		}
+		return p.Parse(s), nil
+	}
+
+	// ten callers arrive together, one init runs and all of them get its error
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Parse("hello"); err != nil {
+				failures.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Println("10 callers:", failures.Load(), "errors,", calls.Load(), "init call")
+
+	_, err := Parse("hello")
+	fmt.Println("during the backoff:", err, "- init calls:", calls.Load())
+
+	clock.Advance(time.Second)
+	out, err := Parse("hello")
+	fmt.Println("after the backoff:", out, err, "- init calls:", calls.Load())
+
+	// the value expires, the caller gets the old one and a refresh starts
+	clock.Advance(time.Minute)
+	out, _ = Parse("hello")
+	fmt.Println("expired, stale value:", out)
+	time.Sleep(50 * time.Millisecond)
+	out, _ = Parse("hello")
+	fmt.Println("refreshed in the background:", out)
+
+	parser.Reset()
+	out, _ = Parse("hello")
+	fmt.Println("after Reset:", out, "- init calls:", calls.Load())
+
+	// a panic in init doesn't take the process down, the callers that waited
+	// for it get a PanicError and the next Get tries again
+	var tries atomic.Int32
+	fragile := NewLazy(func() (int, error) {
+		if tries.Add(1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+			var m map[string]int
+			m["x"] = 1
+		}
+		return 42, nil
+	}, LazyOptions{})
+	var panics atomic.Int32
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var pe *PanicError
+			if _, err := fragile.Get(); errors.As(err, &pe) {
+				panics.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	v, err := fragile.Get()
+	fmt.Println("init panicked:", panics.Load(), "callers got a PanicError, then:", v, err)
+	if v != 42 || err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Adaptive concurrency limit
+// PressureGauge in concurrency4.go is created with New(2), two requests at a time
+// because somebody picked 2. Too low and the server sits idle, too high and a
+// slow dependency makes requests pile up until everything times out. The right
+// number changes with the load, so the Limiter measures it: every finished
+// request reports its latency and whether it failed, and an Algorithm moves the
+// limit from those samples:
+//   - AIMD: additive increase, multiplicative decrease, the TCP congestion idea.
+//     +1 while things go well, cut by a ratio on an error or a slow request
+//   - Gradient: compares the latency with the best one seen (no queueing), when
+//     latency grows the ratio drops below 1 and the limit shrinks with it
+//
+// The samples are grouped in windows, the algorithm sees one Sample per window
+// (average latency, highest concurrency, any failure). Feeding it every request
+// would cut the limit once for each of the many requests that were slow because
+// of the same overload, and grow it by one for each request of a good batch.
+//
+// Acquire is the select with a default of PressureGauge.Process, it never waits,
+// a request over the limit is rejected right away
+
+var ErrLimitExceeded = errors.New("no more capacity")
+
+type Sample struct {
+	Latency  time.Duration // average of the window
+	InFlight int           // the most requests running at once in the window
+	Failed   bool          // errors count as overload, like a timeout would
+}
+
+type Algorithm interface {
+	// Update returns the new limit, the Limiter keeps it between Min and Max
+	Update(limit float64, s Sample) float64
+}
+
+type AIMD struct {
+	Threshold    time.Duration // slower than this counts as overload
+	BackoffRatio float64       // multiplies the limit on overload, 0.9 when 0
+}
+
+func (a AIMD) Update(limit float64, s Sample) float64 {
+	if s.Failed || (a.Threshold > 0 && s.Latency > a.Threshold) {
+		ratio := a.BackoffRatio
+		if ratio == 0 {
+			ratio = 0.9
+		}
+		return limit * ratio
+	}
+	// only grow when the limit is actually being used, a server with 2 requests
+	// in flight learns nothing about a limit of 100
+	if float64(s.InFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Gradient keeps the lowest latency seen as the latency without queueing, the
+// ratio between it and the current latency is the gradient. Tolerance lets the
+// latency grow a bit before reacting and the square root of the limit is a
+// small queue that allows growing when the gradient is 1
+type Gradient struct {
+	Tolerance float64 // 1.2 when 0
+	Smoothing float64 // weight of a new sample, 0.2 when 0
+
+	l      sync.Mutex
+	minRTT time.Duration
+}
+
+func (g *Gradient) Update(limit float64, s Sample) float64 {
+	g.l.Lock()
+	defer g.l.Unlock()
+	tolerance, smoothing := g.Tolerance, g.Smoothing
+	if tolerance == 0 {
+		tolerance = 1.2
+	}
+	if smoothing == 0 {
+		smoothing = 0.2
+	}
+	if g.minRTT == 0 || s.Latency < g.minRTT {
+		g.minRTT = s.Latency
+	}
+	gradient := 0.5
+	if !s.Failed && s.Latency > 0 {
+		gradient = math.Max(0.5, math.Min(1, tolerance*float64(g.minRTT)/float64(s.Latency)))
+	}
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-smoothing) + next*smoothing
+}
+
+type LimiterOptions struct {
+	Initial, Min, Max int
+	Window            time.Duration    // length of a window, 0 updates on every request
+	Now               func() time.Time // clock, time.Now when nil
+}
+
+type Limiter struct {
+	alg  Algorithm
+	opts LimiterOptions
+
+	l        sync.Mutex
+	limit    float64
+	inFlight int
+	// the window being filled
+	windowStart time.Time
+	samples     int
+	totalRTT    time.Duration
+	maxInFlight int
+	failed      bool
+}
+
+func NewLimiter(alg Algorithm, opts LimiterOptions) *Limiter {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	opts.Min = max(opts.Min, 1)
+	if opts.Max < opts.Min {
+		opts.Max = math.MaxInt
+	}
+	return &Limiter{
+		alg:   alg,
+		opts:  opts,
+		limit: float64(min(max(opts.Initial, opts.Min), opts.Max)),
+	}
+}
+
+// Token is a request that was let in, Release must be called once it is done
+type Token struct {
+	lm    *Limiter
+	start time.Time
+	once  sync.Once
+}
+
+// Acquire returns ErrLimitExceeded when the limit is reached
+func (lm *Limiter) Acquire() (*Token, error) {
+	lm.l.Lock()
+	defer lm.l.Unlock()
+	if lm.inFlight >= int(lm.limit) {
+		return nil, ErrLimitExceeded
+	}
+	lm.inFlight++
+	return &Token{lm: lm, start: lm.opts.Now()}, nil
+}
+
+// Release reports how the request went, err != nil is a failure sample
+func (t *Token) Release(err error) {
+	t.once.Do(func() {
+		lm := t.lm
+		now := lm.opts.Now()
+		lm.l.Lock()
+		defer lm.l.Unlock()
+		if lm.samples == 0 {
+			lm.windowStart = now
+		}
+		lm.samples++
+		lm.totalRTT += now.Sub(t.start)
+		lm.maxInFlight = max(lm.maxInFlight, lm.inFlight)
+		lm.failed = lm.failed || err != nil
+		lm.inFlight--
+		if now.Sub(lm.windowStart) < lm.opts.Window {
+			return
+		}
+		s := Sample{
+			Latency:  lm.totalRTT / time.Duration(lm.samples),
+			InFlight: lm.maxInFlight,
+			Failed:   lm.failed,
+		}
+		lm.samples, lm.totalRTT, lm.maxInFlight, lm.failed = 0, 0, 0, false
+		next := lm.alg.Update(lm.limit, s)
+		lm.limit = math.Min(math.Max(next, float64(lm.opts.Min)), float64(lm.opts.Max))
+	})
+}
+
+// Process has the shape of PressureGauge.Process, with f able to fail
+func (lm *Limiter) Process(f func() error) error {
+	t, err := lm.Acquire()
+	if err != nil {
+		return err
+	}
+	// deferred, a panicking f still gives its slot back, and counts as a
+	// failure before the panic goes on
+	completed := false
+	defer func() {
+		if !completed {
+			t.Release(errPanicked)
+		}
+	}()
+	err = f()
+	completed = true
+	t.Release(err)
+	return err
+}
+
+var errPanicked = errors.New("panic in Process")
+
+func (lm *Limiter) Limit() int {
+	lm.l.Lock()
+	defer lm.l.Unlock()
+	return int(lm.limit)
+}
+
+func (lm *Limiter) InFlight() int {
+	lm.l.Lock()
+	defer lm.l.Unlock()
+	return lm.inFlight
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Admission control
+// PressureGauge.Process says "no more capacity" the moment every token is taken,
+// even if one will be free in a millisecond, and every request is the same. The
+// Admission controller lets a request wait for its tokens, with two rules:
+//   - priority classes: a waiting Interactive request always goes before a
+//     waiting Batch one, a user clicking a button doesn't wait behind a report
+//   - weighted costs: a request can take several tokens, an export that hits the
+//     database ten times costs ten
+//
+// Inside a class the order is FIFO, and a big request at the head is not skipped
+// by smaller ones behind it, otherwise it could wait forever.
+//
+// Waiting is bounded by the context deadline and by MaxQueue. It also estimates
+// how long the wait will be (tokens ahead of us times the average time a token
+// is held), if that is past the deadline the request is rejected right away
+// instead of waiting just to fail, and the estimate becomes the Retry-After
+
+type Class int
+
+const (
+	Interactive Class = iota
+	Batch
+	numClasses
+)
+
+var (
+	// ErrQueueFull maps to 429, the client sends too much
+	ErrQueueFull = errors.New("admission queue is full")
+	// ErrOverloaded maps to 503, the wait doesn't fit in the deadline
+	ErrOverloaded = errors.New("server overloaded")
+)
+
+// RejectError carries the estimate for the Retry-After header, errors.Is matches
+// it with ErrQueueFull or ErrOverloaded
+type RejectError struct {
+	Reason     error
+	RetryAfter time.Duration
+}
+
+func (re RejectError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", re.Reason, re.RetryAfter)
+}
+
+func (re RejectError) Unwrap() error {
+	return re.Reason
+}
+
+type AdmissionOptions struct {
+	Capacity    int           // tokens
+	MaxQueue    int           // waiting requests, 0 means no limit
+	InitialHold time.Duration // guess of how long a token is held, before measuring
+}
+
+type waiter struct {
+	cost    int
+	ready   chan struct{} // closed when the tokens were given to this waiter
+	granted bool
+}
+
+type Admission struct {
+	opts AdmissionOptions
+
+	l         sync.Mutex
+	available int
+	queues    [numClasses]*list.List
+	queued    int
+	avgHold   time.Duration // moving average of the time a request holds its tokens
+}
+
+func NewAdmission(opts AdmissionOptions) *Admission {
+	if opts.InitialHold <= 0 {
+		opts.InitialHold = 100 * time.Millisecond
+	}
+	a := &Admission{
+		opts:      opts,
+		available: opts.Capacity,
+		avgHold:   opts.InitialHold,
+	}
+	for i := range a.queues {
+		a.queues[i] = list.New()
+	}
+	return a
+}
+
+// Acquire waits until cost tokens are given to the request, ctx cancels the wait.
+// The returned func gives the tokens back, it must be called exactly once
+func (a *Admission) Acquire(ctx context.Context, class Class, cost int) (func(), error) {
+	if cost < 1 || cost > a.opts.Capacity {
+		return nil, fmt.Errorf("cost %d out of range 1..%d", cost, a.opts.Capacity)
+	}
+	if class < 0 || class >= numClasses {
+		class = Batch
+	}
+	a.l.Lock()
+	if a.available >= cost && a.aheadLocked(class) == 0 {
+		a.available -= cost
+		a.l.Unlock()
+		return a.releaser(cost), nil
+	}
+	wait := a.estimateLocked(class, cost)
+	if a.opts.MaxQueue > 0 && a.queued >= a.opts.MaxQueue {
+		a.l.Unlock()
+		return nil, RejectError{Reason: ErrQueueFull, RetryAfter: wait}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		a.l.Unlock()
+		return nil, RejectError{Reason: ErrOverloaded, RetryAfter: wait}
+	}
+	w := &waiter{cost: cost, ready: make(chan struct{})}
+	e := a.queues[class].PushBack(w)
+	a.queued++
+	a.l.Unlock()
+
+	select {
+	case <-w.ready:
+		return a.releaser(cost), nil
+	case <-ctx.Done():
+		a.l.Lock()
+		if w.granted {
+			// the tokens arrived at the same time as the cancellation, give them
+			// to the next one
+			a.available += cost
+			a.grantLocked()
+		} else {
+			a.queues[class].Remove(e)
+			a.queued--
+			// a big request at the head may have been blocking smaller ones
+			a.grantLocked()
+		}
+		a.l.Unlock()
+		return nil, RejectError{Reason: ErrOverloaded, RetryAfter: wait}
+	}
+}
+
+// aheadLocked is the cost waiting in class and the classes before it
+func (a *Admission) aheadLocked(class Class) int {
+	total := 0
+	for c := Class(0); c <= class; c++ {
+		for e := a.queues[c].Front(); e != nil; e = e.Next() {
+			total += e.Value.(*waiter).cost
+		}
+	}
+	return total
+}
+
+// estimateLocked: the tokens that must be released before ours are free, at the
+// rate the tokens come back (Capacity every avgHold)
+func (a *Admission) estimateLocked(class Class, cost int) time.Duration {
+	missing := a.aheadLocked(class) + cost - a.available
+	if missing <= 0 {
+		return 0
+	}
+	rounds := math.Ceil(float64(missing) / float64(a.opts.Capacity))
+	return time.Duration(rounds) * a.avgHold
+}
+
+// grantLocked hands free tokens to the waiters, in class order and FIFO, and
+// stops at the first one that doesn't fit
+func (a *Admission) grantLocked() {
+	for _, q := range a.queues {
+		for e := q.Front(); e != nil; e = q.Front() {
+			w := e.Value.(*waiter)
+			if w.cost > a.available {
+				return
+			}
+			a.available -= w.cost
+			q.Remove(e)
+			a.queued--
+			w.granted = true
+			close(w.ready)
+		}
+	}
+}
+
+func (a *Admission) releaser(cost int) func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			held := time.Since(start)
+			a.l.Lock()
+			defer a.l.Unlock()
+			// exponential moving average, recent requests weigh more
+			a.avgHold = (a.avgHold*7 + held) / 8
+			a.available += cost
+			a.grantLocked()
+		})
+	}
+}
+
+// AdmissionHandler wraps next: the class comes from the X-Priority header
+// ("batch" or anything else for interactive) and the cost from the cost query
+// parameter. Every request may wait up to maxWait
+func AdmissionHandler(a *Admission, maxWait time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := Interactive
+		if r.Header.Get("X-Priority") == "batch" {
+			class = Batch
+		}
+		cost := 1
+		if c := r.URL.Query().Get("cost"); c != "" {
+			n, err := strconv.Atoi(c)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid cost"))
+				return
+			}
+			cost = n
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), maxWait)
+		defer cancel()
+		release, err := a.Acquire(ctx, class, cost)
+		if err != nil {
+			var re RejectError
+			if !errors.As(err, &re) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			// Retry-After is in whole seconds, round up so the client doesn't
+			// come back too early
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(re.RetryAfter.Seconds())))))
+			if errors.Is(err, ErrQueueFull) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			} else {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			w.Write([]byte(err.Error()))
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeClock is the simulated time, nothing sleeps and every run gives the same
+// numbers
+type fakeClock struct {
+	l sync.Mutex
+	t time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+// The simulated backend handles capacity requests at 10ms each, past that they
+// queue and the latency grows with the load. Over 50ms the caller times out
+const (
+	capacity = 20
+	base     = 10 * time.Millisecond
+	timeout  = 50 * time.Millisecond
+)
+
+func latency(concurrent int) time.Duration {
+	if concurrent <= capacity {
+		return base
+	}
+	return base * time.Duration(concurrent) / capacity
+}
+
+// simulate sends many more requests than the backend can take, in rounds: all the
+// requests the limiter lets in run together, take latency(n) and are released.
+// The limit should end up around the capacity, and once it settles (the second
+// half of the run) no request should time out
+func simulate(name string, alg Algorithm) bool {
+	clock := &fakeClock{t: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)}
+	lm := NewLimiter(alg, LimiterOptions{Initial: 2, Min: 1, Max: 200, Window: base, Now: clock.Now})
+	var history []string
+	timeouts := 0
+	for round := 0; round < 200; round++ {
+		var tokens []*Token
+		for i := 0; i < 100; i++ {
+			t, err := lm.Acquire()
+			if err != nil {
+				break
+			}
+			tokens = append(tokens, t)
+		}
+		d := latency(len(tokens))
+		clock.Advance(d)
+		var err error
+		if d > timeout {
+			err = fmt.Errorf("timed out after %v", d)
+			if round >= 100 {
+				timeouts++
+			}
+		}
+		for _, t := range tokens {
+			t.Release(err)
+		}
+		if round%25 == 0 {
+			history = append(history, fmt.Sprint(lm.Limit()))
+		}
+	}
+	limit := lm.Limit()
+	ok := limit >= capacity/2 && limit <= capacity*2 && timeouts == 0
+	status := "ok  "
+	if !ok {
+		status = "FAIL"
+	}
+	fmt.Printf("%s %-8s limit %s -> %d (capacity %d), late timeouts %d\n",
+		status, name, strings.Join(history, " "), limit, capacity, timeouts)
+	return ok
+}
+
+// call goes through the handler without a server, like a test would, and
+// compares the status and the Retry-After with the expected ones
+func call(name string, h http.Handler, priority string, cost int, code int, retryAfter string) bool {
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/admit?cost=%d", cost), nil)
+	r.Header.Set("X-Priority", priority)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	got := fmt.Sprintf("%d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	if w.Code != code || w.Header().Get("Retry-After") != retryAfter {
+		fmt.Printf("FAIL %s: %s, want %d Retry-After=%q\n", name, got, code, retryAfter)
+		return false
+	}
+	fmt.Printf("ok   %s: %s\n", name, got)
+	return true
+}
+
+// waitQueued spins until n requests are waiting, so the arrival order is known
+func waitQueued(a *Admission, n int) {
+	for {
+		a.l.Lock()
+		q := a.queued
+		a.l.Unlock()
+		if q >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func demoAdmission() bool {
+	a := NewAdmission(AdmissionOptions{Capacity: 4, MaxQueue: 3, InitialHold: 50 * time.Millisecond})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("done"))
+	})
+	h := AdmissionHandler(a, time.Second, next)
+
+	// a big request takes the 4 tokens, two batch requests and then an
+	// interactive one arrive while it runs. Each one takes 3 or 4 tokens, so only
+	// one fits at a time and each keeps them until we read its name
+	release, _ := a.Acquire(context.Background(), Batch, 4)
+	order := make(chan string)
+	finish := map[string]chan struct{}{}
+	for i, r := range []struct {
+		name  string
+		class Class
+		cost  int
+	}{
+		{"batch-1", Batch, 3},
+		{"batch-2", Batch, 3},
+		{"interactive", Interactive, 4},
+	} {
+		done := make(chan struct{})
+		finish[r.name] = done
+		go func() {
+			rel, err := a.Acquire(context.Background(), r.class, r.cost)
+			if err != nil {
+				order <- err.Error()
+				return
+			}
+			order <- r.name
+			<-done
+			rel()
+		}()
+		waitQueued(a, i+1)
+	}
+	ok := call("queue full", h, "batch", 1, http.StatusTooManyRequests, "1")
+	release()
+	var got []string
+	for i := 0; i < 3; i++ {
+		name := <-order
+		got = append(got, name)
+		close(finish[name])
+	}
+	if want := []string{"interactive", "batch-1", "batch-2"}; !slices.Equal(got, want) {
+		fmt.Printf("FAIL admitted in order %v, want %v\n", got, want)
+		ok = false
+	} else {
+		fmt.Println("ok   admitted in order:", got)
+	}
+
+	// the wait estimate (50ms per round of tokens) doesn't fit in 10ms
+	release, _ = a.Acquire(context.Background(), Batch, 4)
+	ok = call("estimated wait too long", AdmissionHandler(a, 10*time.Millisecond, next), "interactive", 1, http.StatusServiceUnavailable, "1") && ok
+	release()
+	return call("free again", h, "interactive", 2, http.StatusOK, "") && ok
+}
+
+func doThingThatShouldBeLimited() string {
+	time.Sleep(2 * time.Second)
+	return "done"
+}
+
+func main() {
+	ok := simulate("aimd", AIMD{Threshold: base * 5 / 4})
+	ok = simulate("gradient", &Gradient{}) && ok
+	ok = demoAdmission() && ok
+	if !ok {
+		os.Exit(1)
+	}
+	if len(os.Args) < 2 || os.Args[1] != "serve" {
+		return
+	}
+
+	// The handler of concurrency4.go, without the New(2)
+	lm := NewLimiter(&Gradient{}, LimiterOptions{Initial: 2, Min: 1, Max: 100, Window: time.Second})
+	http.HandleFunc("/request", func(w http.ResponseWriter, r *http.Request) {
+		err := lm.Process(func() error {
+			w.Write([]byte(doThingThatShouldBeLimited()))
+			return nil
+		})
+		if err == ErrLimitExceeded {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("Too many requests"))
+		}
+	})
+	// and the waiting version, interactive requests first
+	admission := NewAdmission(AdmissionOptions{Capacity: 2, MaxQueue: 20, InitialHold: 2 * time.Second})
+	http.Handle("/admit", AdmissionHandler(admission, 5*time.Second, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(doThingThatShouldBeLimited()))
+		})))
+	http.ListenAndServe(":8080", nil)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+var failed bool
+
+func check(name string, ok bool) {
+	if !ok {
+		failed = true
+		fmt.Println("FAIL", name)
+	}
+}
+
+// drain ranges over Out until it closes, a merger that never closes it fails
+// after a second instead of hanging
+func drain(m *Merger[string]) ([]string, bool) {
+	var got []string
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case v, ok := <-m.Out():
+			if !ok {
+				return got, true
+			}
+			got = append(got, v)
+		case <-timeout:
+			return got, false
+		}
+	}
+}
+
+// source writes n values with a prefix, then closes its channel
+func source(prefix string, n int, every time.Duration) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for i := 0; i < n; i++ {
+			time.Sleep(every)
+			ch <- fmt.Sprintf("%s%d", prefix, i)
+		}
+	}()
+	return ch
+}
+
+// buffered has all of its values ready before anybody reads
+func buffered(prefix string, n int) <-chan string {
+	ch := make(chan string, n)
+	for i := 0; i < n; i++ {
+		ch <- fmt.Sprintf("%s%d", prefix, i)
+	}
+	close(ch)
+	return ch
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// two inputs at the start, a third one added while merging, and one that is
+	// removed before it says anything. Out closes when the rest are drained
+	m := Merge(ctx, Fair, source("a", 3, time.Millisecond), source("b", 3, time.Millisecond))
+	never := make(chan string)
+	m.Add(never)
+	var got []string
+	for v := range m.Out() {
+		got = append(got, v)
+		if len(got) == 1 {
+			m.Add(source("c", 2, time.Millisecond))
+			fmt.Println("removed the silent input:", m.Remove(never))
+		}
+	}
+	fmt.Println("fair:", got, len(got), "values")
+	check("fair merge lost values", len(got) == 8)
+	added := m.Add(source("d", 1, 0))
+	fmt.Println("add after the end:", added)
+	check("add after the end", !added)
+
+	// nothing keeps Merge open once its inputs are drained, ranging over Out ends
+	got, closed := drain(Merge(ctx, Fair, buffered("a", 2), buffered("b", 2)))
+	fmt.Println("drained:", len(got), "values, out closed:", closed)
+	check("out closes when the inputs are drained", closed && len(got) == 4)
+
+	// no inputs at the start, they only show up at runtime. The first one is
+	// drained before the second arrives, MergeOpen keeps going until Close
+	e := MergeOpen[string](ctx, Fair)
+	e.Add(buffered("x", 2))
+	got = []string{<-e.Out(), <-e.Out()}
+	time.Sleep(10 * time.Millisecond)
+	added = e.Add(buffered("y", 1))
+	fmt.Println("add to an empty merger:", added)
+	check("add to an empty open merger", added)
+	e.Close()
+	rest, closed := drain(e)
+	got = append(got, rest...)
+	fmt.Println("runtime inputs:", got)
+	check("runtime inputs", closed && slices.Equal(got, []string{"x0", "x1", "y0"}))
+
+	// low and high both have values ready, high always goes first
+	got, closed = drain(Merge(ctx, Priority, buffered("high", 3), buffered("low", 3)))
+	fmt.Println("priority:", got)
+	check("priority order", closed && slices.Equal(got, []string{"high0", "high1", "high2", "low0", "low1", "low2"}))
+
+	// canceling the context closes Out even if the inputs never end
+	cctx, ccancel := context.WithCancel(ctx)
+	c := Merge(cctx, Fair, (<-chan string)(never))
+	ccancel()
+	_, open := <-c.Out()
+	fmt.Println("out open after cancel:", open)
+	check("out closes on cancel", !open)
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// Merging any number of channels
+// concurrency5.go turns off a case of a select by setting its channel to nil once
+// it is closed, that works when the channels are known when writing the code, a
+// select has a fixed number of cases. reflect.Select takes the cases as a slice,
+// so it can be built at runtime, and "set the channel to nil" becomes "remove it
+// from the slice".
+//
+// Merger owns one goroutine that reads from the inputs and writes to Out. Inputs
+// can be added or removed while it runs, those requests travel through a control
+// channel that is one more case of the select. Out is closed when every input has
+// been drained (or removed), or when ctx is canceled. When the inputs only show
+// up later, MergeOpen keeps Out open with no inputs until Close is called
+
+type Mode int
+
+const (
+	// Fair reads from whichever input is ready, reflect.Select picks at random
+	// among the ready ones, so a busy input can't starve the others
+	Fair Mode = iota
+	// Priority always reads from the ready input with the highest priority first,
+	// when nothing is ready it waits for all of them and takes what comes first
+	Priority
+)
+
+type input[T any] struct {
+	ch       <-chan T
+	priority int
+}
+
+type control[T any] struct {
+	add    *input[T]
+	remove <-chan T
+	close  bool
+	reply  chan bool
+}
+
+type Merger[T any] struct {
+	ctx      context.Context
+	mode     Mode
+	out      chan T
+	ctl      chan control[T]
+	done     chan struct{} // closed when the goroutine exits, Add and Remove give up
+	ins      []input[T]
+	keepOpen bool // running out of inputs doesn't end it, only Close does
+	closed   bool // no more Adds, Out closes when ins is empty
+}
+
+// Merge starts merging chans. In Priority mode the first channel has the highest
+// priority, the second the next one, and so on. Out is closed once they are all
+// drained, inputs added before that are drained too
+func Merge[T any](ctx context.Context, mode Mode, chans ...<-chan T) *Merger[T] {
+	return start(ctx, mode, false, chans)
+}
+
+// MergeOpen is Merge for inputs that come and go at runtime: Out stays open when
+// there are no inputs left, the next Add starts it again. Call Close when no more
+// inputs are coming, or cancel ctx
+func MergeOpen[T any](ctx context.Context, mode Mode, chans ...<-chan T) *Merger[T] {
+	return start(ctx, mode, true, chans)
+}
+
+func start[T any](ctx context.Context, mode Mode, keepOpen bool, chans []<-chan T) *Merger[T] {
+	m := &Merger[T]{
+		ctx:      ctx,
+		mode:     mode,
+		out:      make(chan T),
+		ctl:      make(chan control[T]),
+		done:     make(chan struct{}),
+		keepOpen: keepOpen,
+	}
+	for i, ch := range chans {
+		m.ins = append(m.ins, input[T]{ch: ch, priority: len(chans) - i})
+	}
+	go m.run()
+	return m
+}
+
+func (m *Merger[T]) Out() <-chan T {
+	return m.out
+}
+
+// Add starts reading ch with priority 0, it returns false if the merger was
+// closed or already finished
+func (m *Merger[T]) Add(ch <-chan T) bool {
+	return m.AddWithPriority(ch, 0)
+}
+
+// AddWithPriority is Add for Priority mode, a higher value is read first
+func (m *Merger[T]) AddWithPriority(ch <-chan T, priority int) bool {
+	return m.send(control[T]{add: &input[T]{ch: ch, priority: priority}})
+}
+
+// Remove stops reading ch without draining it, the values left in it are not
+// merged. It returns false if ch was not an input
+func (m *Merger[T]) Remove(ch <-chan T) bool {
+	return m.send(control[T]{remove: ch})
+}
+
+// Close says no more inputs are coming, Out is closed once the current ones
+// are drained. It returns false if the merger was already closed or finished
+func (m *Merger[T]) Close() bool {
+	return m.send(control[T]{close: true})
+}
+
+func (m *Merger[T]) send(c control[T]) bool {
+	c.reply = make(chan bool, 1)
+	select {
+	case m.ctl <- c:
+		return <-c.reply
+	case <-m.done:
+		return false
+	}
+}
+
+func (m *Merger[T]) handle(c control[T]) {
+	switch {
+	case c.close:
+		c.reply <- !m.closed
+		m.closed = true
+		return
+	case c.add != nil:
+		if !m.closed {
+			m.ins = append(m.ins, *c.add)
+		}
+		c.reply <- !m.closed
+		return
+	}
+	for i, in := range m.ins {
+		if in.ch == c.remove {
+			m.ins = append(m.ins[:i], m.ins[i+1:]...)
+			c.reply <- true
+			return
+		}
+	}
+	c.reply <- false
+}
+
+func (m *Merger[T]) run() {
+	defer close(m.done)
+	defer close(m.out)
+	// with no inputs (MergeOpen) receive only waits for a control request or ctx
+	for m.ctx.Err() == nil && !m.finished() {
+		v, ok := m.receive()
+		if !ok {
+			continue
+		}
+		// while Out is blocked, Add and Remove still have to be served
+		for sent := false; !sent; {
+			select {
+			case m.out <- v:
+				sent = true
+			case c := <-m.ctl:
+				m.handle(c)
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (m *Merger[T]) finished() bool {
+	return len(m.ins) == 0 && (m.closed || !m.keepOpen)
+}
+
+// receive returns a value, ok is false when the select returned something else
+// (a control request, a closed input) and the loop has to check again
+func (m *Merger[T]) receive() (T, bool) {
+	var zero T
+	if m.mode == Priority {
+		n := len(m.ins)
+		if v, ok := m.receiveByPriority(); ok {
+			return v, true
+		}
+		if len(m.ins) != n {
+			// an input was closed, it may have been the last one
+			return zero, false
+		}
+	}
+	cases := m.inputCases()
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.ctl)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.ctx.Done())},
+	)
+	chosen, v, ok := reflect.Select(cases)
+	switch {
+	case chosen == len(cases)-2:
+		m.handle(v.Interface().(control[T]))
+		return zero, false
+	case chosen == len(cases)-1:
+		// ctx is done, the loop sees it
+		return zero, false
+	case !ok:
+		// closed, the nil channel pattern: this input is never selected again
+		m.ins = append(m.ins[:chosen], m.ins[chosen+1:]...)
+		return zero, false
+	}
+	return v.Interface().(T), true
+}
+
+func (m *Merger[T]) inputCases() []reflect.SelectCase {
+	cases := make([]reflect.SelectCase, len(m.ins))
+	for i, in := range m.ins {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(in.ch)}
+	}
+	return cases
+}
+
+// receiveByPriority tries each priority level without blocking, the default case
+// makes reflect.Select return at once if none of them is ready
+func (m *Merger[T]) receiveByPriority() (T, bool) {
+	var zero T
+	sort.SliceStable(m.ins, func(i, j int) bool {
+		return m.ins[i].priority > m.ins[j].priority
+	})
+	for start := 0; start < len(m.ins); {
+		end := start
+		for end < len(m.ins) && m.ins[end].priority == m.ins[start].priority {
+			end++
+		}
+		cases := m.inputCases()[start:end]
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		chosen, v, ok := reflect.Select(cases)
+		switch {
+		case chosen == len(cases)-1:
+			start = end
+		case !ok:
+			m.ins = append(m.ins[:start+chosen], m.ins[start+chosen+1:]...)
+			return zero, false
+		default:
+			return v.Interface().(T), true
+		}
+	}
+	return zero, false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func square(ctx context.Context, v int) (int, error) {
+	// later inputs finish first, without Ordered they would come out reversed
+	time.Sleep(time.Duration(10-v) * time.Millisecond)
+	return v * v, nil
+}
+
+func fragile(ctx context.Context, v int) (int, error) {
+	switch v {
+	case 3:
+		return 0, errors.New("three is not allowed")
+	case 6:
+		var m map[string]int
+		m["boom"] = v // panics, writing to a nil map
+	}
+	return v * 10, nil
+}
+
+func main() {
+	ctx := context.Background()
+	inputs := []int{1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	out, err := MapSlice(ctx, inputs, square, Options{Workers: 3, Ordered: true})
+	fmt.Println("ordered:", out, err)
+
+	// every task runs, the panic of task 5 (input 6) is just one more error
+	out, err = MapSlice(ctx, inputs, fragile, Options{Workers: 3, Ordered: true, Errors: CollectAll})
+	fmt.Println("collect all:", out)
+	fmt.Println(err)
+	var pe PanicError
+	fmt.Println("a panic was recovered:", errors.As(err, &pe))
+
+	// the first error cancels the rest
+	_, err = MapSlice(ctx, inputs, fragile, Options{Workers: 1, Errors: FailFast})
+	fmt.Println("fail fast:", err)
+
+	// Backpressure: the producer wants to write 50 values, but with 2 workers and a
+	// backlog of 4 it can only be a few values ahead of them. The metrics are read
+	// while Map runs
+	var metrics Metrics
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 50; i++ {
+			in <- i
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s := metrics.Snapshot()
+				fmt.Printf("queue=%d in flight=%d completed=%d\n", s.QueueDepth, s.InFlight, s.Completed)
+			}
+		}
+	}()
+	slow := func(ctx context.Context, v int) (int, error) {
+		time.Sleep(5 * time.Millisecond)
+		return v, nil
+	}
+	out, err = Map(ctx, in, slow, Options{Workers: 2, Backlog: 4, Metrics: &metrics})
+	close(done)
+	s := metrics.Snapshot()
+	fmt.Printf("processed %d, err %v, avg latency %v, max latency %v\n",
+		len(out), err, s.AvgLatency.Round(time.Millisecond), s.MaxLatency.Round(time.Millisecond))
+}
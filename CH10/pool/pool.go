@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounded worker pool
+// processAndGather in concurrency6.go launches num goroutines that range over the
+// input channel and gather the results, it works but the results come in any
+// order, an error can't be reported, a panic in processor kills the program and
+// nothing says how busy the workers are. Map keeps the same shape (a fixed number
+// of workers reading from a channel) and adds those pieces:
+//
+//	in -> feeder -> jobs (buffered, the backlog) -> workers -> results -> gather
+//
+// The backlog is a buffered channel, when it is full the feeder blocks and stops
+// reading in, so whoever writes to in blocks too. That is the backpressure: a fast
+// producer can't pile up unlimited work in memory
+
+type ErrorMode int
+
+const (
+	// FailFast cancels the rest of the work on the first error and returns it
+	FailFast ErrorMode = iota
+	// CollectAll runs every task and returns all the errors joined
+	CollectAll
+)
+
+type Options struct {
+	Workers int  // goroutines running tasks, at least 1
+	Backlog int  // tasks read from in but not started yet
+	Ordered bool // results in the same order as the input
+	Errors  ErrorMode
+	Metrics *Metrics // optional, can be read while Map runs
+}
+
+// TaskError tells which input failed, Index is its position in the input
+type TaskError struct {
+	Index int
+	Err   error
+}
+
+func (te TaskError) Error() string {
+	return fmt.Sprintf("task %d: %v", te.Index, te.Err)
+}
+
+func (te TaskError) Unwrap() error {
+	return te.Err
+}
+
+// A panic in one task becomes an error of that task, the worker and the rest of
+// the tasks keep going
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (pe PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// Metrics are updated with atomics, so they can be read from another goroutine
+// while the pool runs
+type Metrics struct {
+	queued       atomic.Int64
+	inFlight     atomic.Int64
+	completed    atomic.Int64
+	failed       atomic.Int64
+	panics       atomic.Int64
+	totalLatency atomic.Int64 // nanoseconds
+	maxLatency   atomic.Int64
+}
+
+type MetricsSnapshot struct {
+	QueueDepth int64 // waiting in the backlog
+	InFlight   int64
+	Completed  int64 // including the failed ones
+	Failed     int64
+	Panics     int64
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+}
+
+// Snapshot reads every metric. A worker can take a job before the feeder counts
+// it, for a moment the counter is -1, QueueDepth never goes below 0
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		QueueDepth: max(m.queued.Load(), 0),
+		InFlight:   m.inFlight.Load(),
+		Completed:  m.completed.Load(),
+		Failed:     m.failed.Load(),
+		Panics:     m.panics.Load(),
+		MaxLatency: time.Duration(m.maxLatency.Load()),
+	}
+	if s.Completed > 0 {
+		s.AvgLatency = time.Duration(m.totalLatency.Load() / s.Completed)
+	}
+	return s
+}
+
+func (m *Metrics) observe(d time.Duration, err error) {
+	m.completed.Add(1)
+	m.totalLatency.Add(int64(d))
+	for {
+		cur := m.maxLatency.Load()
+		if int64(d) <= cur || m.maxLatency.CompareAndSwap(cur, int64(d)) {
+			break
+		}
+	}
+	if err != nil {
+		m.failed.Add(1)
+		var pe PanicError
+		if errors.As(err, &pe) {
+			m.panics.Add(1)
+		}
+	}
+}
+
+type job[T any] struct {
+	index int
+	val   T
+}
+
+type result[R any] struct {
+	index int
+	val   R
+	err   error
+}
+
+// Map runs fn over every value read from in, with opts.Workers goroutines.
+//
+// With Ordered, the result of the i-th input is at index i; in CollectAll mode a
+// failed task leaves the zero value in its place and its TaskError in the joined
+// error. In FailFast mode Map stops reading in after the first error, the producer
+// should stop writing when ctx is done or when Map returns, like the done channel
+// pattern, or it will block forever
+func Map[T, R any](ctx context.Context, in <-chan T, fn func(context.Context, T) (R, error), opts Options) ([]R, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = &Metrics{}
+	}
+	jobs := make(chan job[T], max(opts.Backlog, 0))
+	results := make(chan result[R])
+
+	// feeder, it numbers the inputs so the order can be rebuilt
+	go func() {
+		defer close(jobs)
+		index := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				// counted once it is in the backlog, the one the feeder holds
+				// while the backlog is full is not waiting there yet
+				select {
+				case jobs <- job[T]{index: index, val: v}:
+					metrics.queued.Add(1)
+					index++
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	workers := max(opts.Workers, 1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				metrics.queued.Add(-1)
+				if ctx.Err() != nil {
+					// canceled, drain the backlog without running anything
+					continue
+				}
+				metrics.inFlight.Add(1)
+				start := time.Now()
+				val, err := runTask(ctx, fn, j.val)
+				metrics.inFlight.Add(-1)
+				metrics.observe(time.Since(start), err)
+				if err != nil {
+					err = TaskError{Index: j.index, Err: err}
+				}
+				results <- result[R]{index: j.index, val: val, err: err}
+			}
+		}()
+	}
+	// monitoring goroutine, the same as in processAndGather
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var out []R
+	var errs []error
+	for r := range results {
+		// the loop keeps reading after an error in FailFast mode, the workers
+		// that were already running must be able to send and exit
+		if r.err != nil {
+			if opts.Errors == FailFast && len(errs) == 0 {
+				cancel()
+			}
+			errs = append(errs, r.err)
+		}
+		if !opts.Ordered {
+			if r.err == nil {
+				out = append(out, r.val)
+			}
+			continue
+		}
+		for len(out) <= r.index {
+			var zero R
+			out = append(out, zero)
+		}
+		out[r.index] = r.val
+	}
+	if len(errs) > 0 {
+		if opts.Errors == FailFast {
+			return out, errs[0]
+		}
+		return out, errors.Join(errs...)
+	}
+	// the parent context may have been canceled, then not every input was read
+	return out, context.Cause(ctx)
+}
+
+func runTask[T, R any](ctx context.Context, fn func(context.Context, T) (R, error), v T) (out R, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx, v)
+}
+
+// MapSlice is Map for when all the inputs are already in memory
+func MapSlice[T, R any](ctx context.Context, vals []T, fn func(context.Context, T) (R, error), opts Options) ([]R, error) {
+	in := make(chan T)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(in)
+		for _, v := range vals {
+			select {
+			case in <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return Map(ctx, in, fn, opts)
+}
@@ -0,0 +1,106 @@
+package main
+
+// Channel actor version, a single goroutine owns the map and the rest of the
+// program sends it functions to run, just like scoreboardManager
+
+func storeManager[K comparable, V any](in <-chan func(map[K]V), done <-chan struct{}) {
+	m := map[K]V{}
+	for {
+		select {
+		case <-done:
+			return
+		case f := <-in:
+			f(m)
+		}
+	}
+}
+
+type ChannelStore[K comparable, V any] chan func(map[K]V)
+
+// The returned func stops the manager goroutine, after calling it the store must
+// not be used, the sends would block forever
+func NewChannelStore[K comparable, V any]() (ChannelStore[K, V], func()) {
+	ch := make(ChannelStore[K, V])
+	done := make(chan struct{})
+	go storeManager(ch, done)
+	return ch, func() {
+		close(done)
+	}
+}
+
+// Every method that returns something waits on a done channel, the closure writes
+// the values before closing it, so reading them afterwards is safe
+func (cs ChannelStore[K, V]) do(f func(map[K]V)) {
+	done := make(chan struct{})
+	cs <- func(m map[K]V) {
+		f(m)
+		close(done)
+	}
+	<-done
+}
+
+func (cs ChannelStore[K, V]) Read(key K) (V, bool) {
+	var out V
+	var ok bool
+	cs.do(func(m map[K]V) {
+		out, ok = m[key]
+	})
+	return out, ok
+}
+
+func (cs ChannelStore[K, V]) Set(key K, val V) {
+	// no result, there is nothing to wait for
+	cs <- func(m map[K]V) {
+		m[key] = val
+	}
+}
+
+func (cs ChannelStore[K, V]) Delete(key K) {
+	cs <- func(m map[K]V) {
+		delete(m, key)
+	}
+}
+
+func (cs ChannelStore[K, V]) CompareAndSwap(key K, old, new V) bool {
+	var swapped bool
+	cs.do(func(m map[K]V) {
+		if cur, ok := m[key]; ok && equal(cur, old) {
+			m[key] = new
+			swapped = true
+		}
+	})
+	return swapped
+}
+
+func (cs ChannelStore[K, V]) Update(key K, fn func(old V, ok bool) V) V {
+	var out V
+	cs.do(func(m map[K]V) {
+		old, ok := m[key]
+		out = fn(old, ok)
+		m[key] = out
+	})
+	return out
+}
+
+func (cs ChannelStore[K, V]) Range(fn func(key K, val V) bool) {
+	var snapshot map[K]V
+	cs.do(func(m map[K]V) {
+		snapshot = make(map[K]V, len(m))
+		for k, v := range m {
+			snapshot[k] = v
+		}
+	})
+	for k, v := range snapshot {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (cs ChannelStore[K, V]) Len() int {
+	var n int
+	cs.do(func(m map[K]V) {
+		n = len(m)
+	})
+	return n
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Snapshot and restore
+// The stores live in memory, when the process exits the scores are gone.
+// DurableStore wraps any Store and saves it in two ways:
+//
+//   - snapshots: the whole map written to a file, periodically or on demand. The
+//     file is written to a temporary name and renamed, a rename is atomic, so the
+//     snapshot on disk is always the old one or the new one, never half of each
+//   - write-ahead log (optional): every write is appended to a log and synced
+//     before it reaches the store and before the method returns. When a method
+//     returns without error the update is acknowledged and survives a crash
+//
+// On startup the latest snapshot is loaded and the log entries written after it
+// are replayed. Without the log, the updates since the last snapshot are lost
+
+type Format int
+
+const (
+	JSON Format = iota
+	Gob
+)
+
+func (f Format) encode(w io.Writer, v any) error {
+	if f == Gob {
+		return gob.NewEncoder(w).Encode(v)
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (f Format) decode(r io.Reader, v any) error {
+	if f == Gob {
+		return gob.NewDecoder(r).Decode(v)
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+func (f Format) ext() string {
+	if f == Gob {
+		return ".gob"
+	}
+	return ".json"
+}
+
+type DurableOptions struct {
+	Dir      string
+	Format   Format
+	WAL      bool          // log every write before acknowledging it
+	Interval time.Duration // time between automatic snapshots, 0 turns them off
+	OnError  func(error)   // errors from the automatic snapshots, they have no caller
+}
+
+// A pair instead of a map, JSON only allows a few types as map keys
+type snapshotEntry[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+type snapshot[K comparable, V any] struct {
+	Seq     uint64 // last log record included in the snapshot
+	Entries []snapshotEntry[K, V]
+}
+
+const (
+	opSet byte = iota + 1
+	opDelete
+)
+
+type walRecord[K comparable, V any] struct {
+	Seq uint64
+	Op  byte
+	Key K
+	Val V
+}
+
+type DurableStore[K comparable, V any] struct {
+	// Writes are serialized with l, the order in the log is the order in which
+	// they were applied. Reads go straight to the store
+	l     sync.Mutex
+	store Store[K, V]
+	opts  DurableOptions
+	wal   *os.File
+	good  int64 // where the last complete record of wal ends
+	fail  error // set when wal couldn't be put back to good, no more writes
+	seq   uint64
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func (ds *DurableStore[K, V]) snapshotPath() string {
+	return filepath.Join(ds.opts.Dir, "snapshot"+ds.opts.Format.ext())
+}
+
+func (ds *DurableStore[K, V]) walPath() string {
+	return filepath.Join(ds.opts.Dir, "wal.log")
+}
+
+// OpenDurableStore restores store (it should be empty) from the files in
+// opts.Dir, creating the directory if needed, and starts the automatic snapshots
+func OpenDurableStore[K comparable, V any](store Store[K, V], opts DurableOptions) (*DurableStore[K, V], error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("in OpenDurableStore: %w", err)
+	}
+	ds := &DurableStore[K, V]{
+		store: store,
+		opts:  opts,
+		done:  make(chan struct{}),
+	}
+	if err := ds.restore(); err != nil {
+		return nil, fmt.Errorf("in OpenDurableStore: %w", err)
+	}
+	if opts.Interval > 0 {
+		ds.wg.Add(1)
+		go ds.snapshotLoop()
+	}
+	return ds, nil
+}
+
+func (ds *DurableStore[K, V]) restore() error {
+	f, err := os.Open(ds.snapshotPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// first start, nothing to restore
+	case err != nil:
+		return err
+	default:
+		var snap snapshot[K, V]
+		err := ds.opts.Format.decode(bufio.NewReader(f), &snap)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", ds.snapshotPath(), err)
+		}
+		for _, e := range snap.Entries {
+			ds.store.Set(e.Key, e.Val)
+		}
+		ds.seq = snap.Seq
+	}
+	if !ds.opts.WAL {
+		return nil
+	}
+	return ds.replay()
+}
+
+// Every record in the log is [length][crc32][encoded walRecord]. A crash in the
+// middle of an append leaves a torn record at the end, the checksum catches it.
+// That write never returned, so it was never acknowledged and we can drop it
+func (ds *DurableStore[K, V]) replay() error {
+	f, err := os.OpenFile(ds.walPath(), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var good int64 // end of the last complete record
+	for {
+		payload, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		var rec walRecord[K, V]
+		if err := ds.opts.Format.decode(bytes.NewReader(payload), &rec); err != nil {
+			break
+		}
+		good += int64(8 + len(payload))
+		// records older than the snapshot are already in it, a crash between
+		// writing the snapshot and truncating the log leaves them behind
+		if rec.Seq <= ds.seq {
+			continue
+		}
+		ds.apply(rec)
+		ds.seq = rec.Seq
+	}
+	// cut the torn tail, otherwise the next records would be appended after it
+	// and be unreadable
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	ds.wal = f
+	ds.good = good
+	return nil
+}
+
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header[:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, errors.New("corrupted record")
+	}
+	return payload, nil
+}
+
+func (ds *DurableStore[K, V]) apply(rec walRecord[K, V]) {
+	switch rec.Op {
+	case opSet:
+		ds.store.Set(rec.Key, rec.Val)
+	case opDelete:
+		ds.store.Delete(rec.Key)
+	}
+}
+
+// logLocked appends the record and syncs it, only then the change is applied.
+// It must be called with l held
+func (ds *DurableStore[K, V]) logLocked(op byte, key K, val V) error {
+	if ds.fail != nil {
+		return ds.fail
+	}
+	rec := walRecord[K, V]{Seq: ds.seq + 1, Op: op, Key: key, Val: val}
+	if ds.wal != nil {
+		var payload bytes.Buffer
+		if err := ds.opts.Format.encode(&payload, rec); err != nil {
+			return err
+		}
+		buf := make([]byte, 8, 8+payload.Len())
+		binary.LittleEndian.PutUint32(buf[:4], uint32(payload.Len()))
+		binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload.Bytes()))
+		buf = append(buf, payload.Bytes()...)
+		if _, err := ds.wal.Write(buf); err != nil {
+			return ds.rollbackLocked(err)
+		}
+		if err := ds.wal.Sync(); err != nil {
+			return ds.rollbackLocked(err)
+		}
+		ds.good += int64(len(buf))
+	}
+	ds.seq = rec.Seq
+	ds.apply(rec)
+	return nil
+}
+
+// rollbackLocked cuts what a failed write left after the last good record.
+// Without it the next record would go after the broken bytes, and on restart
+// replay would stop at them and truncate every acknowledged write that came
+// later. If even that fails the store refuses all writes from then on
+func (ds *DurableStore[K, V]) rollbackLocked(err error) error {
+	if terr := ds.wal.Truncate(ds.good); terr != nil {
+		ds.fail = fmt.Errorf("wal is broken after %w, and it can't be truncated: %v", err, terr)
+		return ds.fail
+	}
+	if _, serr := ds.wal.Seek(ds.good, io.SeekStart); serr != nil {
+		ds.fail = fmt.Errorf("wal is broken after %w, and it can't be rewound: %v", err, serr)
+		return ds.fail
+	}
+	return err
+}
+
+func (ds *DurableStore[K, V]) Read(key K) (V, bool) {
+	return ds.store.Read(key)
+}
+
+func (ds *DurableStore[K, V]) Range(fn func(key K, val V) bool) {
+	ds.store.Range(fn)
+}
+
+func (ds *DurableStore[K, V]) Len() int {
+	return ds.store.Len()
+}
+
+// The writes return an error, unlike Store, a write that couldn't be logged was
+// not applied and the caller has to know it
+
+func (ds *DurableStore[K, V]) Set(key K, val V) error {
+	ds.l.Lock()
+	defer ds.l.Unlock()
+	return ds.logLocked(opSet, key, val)
+}
+
+func (ds *DurableStore[K, V]) Delete(key K) error {
+	ds.l.Lock()
+	defer ds.l.Unlock()
+	var zero V
+	return ds.logLocked(opDelete, key, zero)
+}
+
+func (ds *DurableStore[K, V]) CompareAndSwap(key K, old, new V) (bool, error) {
+	ds.l.Lock()
+	defer ds.l.Unlock()
+	// nobody else writes while we hold l, so the value can't change between the
+	// Read and the Set
+	cur, ok := ds.store.Read(key)
+	if !ok || !equal(cur, old) {
+		return false, nil
+	}
+	if err := ds.logLocked(opSet, key, new); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (ds *DurableStore[K, V]) Update(key K, fn func(old V, ok bool) V) (V, error) {
+	ds.l.Lock()
+	defer ds.l.Unlock()
+	old, ok := ds.store.Read(key)
+	val := fn(old, ok)
+	if err := ds.logLocked(opSet, key, val); err != nil {
+		var zero V
+		return zero, err
+	}
+	return val, nil
+}
+
+// Snapshot writes the whole store to disk and empties the log. Writes wait while
+// it runs, so the snapshot and the log position always agree
+func (ds *DurableStore[K, V]) Snapshot() error {
+	ds.l.Lock()
+	defer ds.l.Unlock()
+	snap := snapshot[K, V]{Seq: ds.seq}
+	ds.store.Range(func(key K, val V) bool {
+		snap.Entries = append(snap.Entries, snapshotEntry[K, V]{Key: key, Val: val})
+		return true
+	})
+	if err := ds.writeSnapshot(snap); err != nil {
+		return fmt.Errorf("in Snapshot: %w", err)
+	}
+	if ds.wal != nil {
+		// the snapshot has everything, an old record left in wal is skipped
+		// on replay, but the position must be right for the next one
+		if err := ds.wal.Truncate(0); err != nil {
+			return fmt.Errorf("in Snapshot: %w", err)
+		}
+		if _, err := ds.wal.Seek(0, io.SeekStart); err != nil {
+			ds.fail = fmt.Errorf("in Snapshot: wal can't be rewound: %w", err)
+			return ds.fail
+		}
+		ds.good = 0
+	}
+	return nil
+}
+
+func (ds *DurableStore[K, V]) writeSnapshot(snap snapshot[K, V]) error {
+	tmp, err := os.CreateTemp(ds.opts.Dir, "snapshot-*.tmp")
+	if err != nil {
+		return err
+	}
+	// if anything fails the temporary file is removed, after the rename there is
+	// nothing left to remove and the call fails silently
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if err := ds.opts.Format.encode(w, snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ds.snapshotPath()); err != nil {
+		return err
+	}
+	// the rename lives in the directory, sync it too or a crash can undo it
+	dir, err := os.Open(ds.opts.Dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (ds *DurableStore[K, V]) snapshotLoop() {
+	defer ds.wg.Done()
+	ticker := time.NewTicker(ds.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ds.done:
+			return
+		case <-ticker.C:
+			if err := ds.Snapshot(); err != nil && ds.opts.OnError != nil {
+				ds.opts.OnError(err)
+			}
+		}
+	}
+}
+
+// Close stops the automatic snapshots, takes a last one and closes the log
+func (ds *DurableStore[K, V]) Close() error {
+	close(ds.done)
+	ds.wg.Wait()
+	err := ds.Snapshot()
+	if ds.wal != nil {
+		if cerr := ds.wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Leaderboard
+// The Store gives us a flat map, a game also needs order: what is my rank, who
+// are the best ten, who is right above and below me. Keeping the scores in a map
+// and the order in a sorted slice behind the same RWMutex means a reader always
+// sees both of them at the same point in time, a Top call never mixes scores from
+// before and after a write. A write costs O(n) to move the player in the slice,
+// reads are O(log n) or O(k), which is the right trade for a scoreboard
+
+// Window says when the scores go back to zero
+type Window int
+
+const (
+	AllTime Window = iota
+	Daily          // resets at midnight
+	Weekly         // resets on Monday at midnight
+)
+
+type Entry struct {
+	Player string
+	Score  int
+	Rank   int // 1 is the best, tied players share the rank (1, 2, 2, 4)
+}
+
+type Leaderboard struct {
+	l      sync.RWMutex
+	window Window
+	now    func() time.Time // injectable, so tests don't wait a day for a reset
+	end    time.Time        // when the current window closes
+	scores map[string]int
+	order  []Entry // best first, ties broken by name so the order is stable
+}
+
+// now is usually time.Now, pass a fake clock to control the resets
+func NewLeaderboard(window Window, now func() time.Time) *Leaderboard {
+	lb := &Leaderboard{
+		window: window,
+		now:    now,
+		scores: map[string]int{},
+	}
+	lb.end = lb.windowEnd(now())
+	return lb
+}
+
+func (lb *Leaderboard) windowEnd(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch lb.window {
+	case Daily:
+		return midnight.AddDate(0, 0, 1)
+	case Weekly:
+		// time.Weekday starts on Sunday, we want Monday to be day 0
+		sinceMonday := (int(t.Weekday()) + 6) % 7
+		return midnight.AddDate(0, 0, 7-sinceMonday)
+	default:
+		return time.Time{}
+	}
+}
+
+// The reset happens the first time the leaderboard is touched after the window
+// ends, there is no timer goroutine to clean up and nobody can read a score from
+// the previous window. It must be called with the write lock held
+func (lb *Leaderboard) rollLocked() {
+	if lb.end.IsZero() {
+		return
+	}
+	now := lb.now()
+	if now.Before(lb.end) {
+		return
+	}
+	lb.scores = map[string]int{}
+	lb.order = nil
+	lb.end = lb.windowEnd(now)
+}
+
+// Readers only take the read lock, unless the window expired
+func (lb *Leaderboard) rlock() {
+	lb.l.RLock()
+	if lb.end.IsZero() || lb.now().Before(lb.end) {
+		return
+	}
+	lb.l.RUnlock()
+	lb.l.Lock()
+	lb.rollLocked()
+	lb.l.Unlock()
+	lb.l.RLock()
+}
+
+func ranksBefore(a, b Entry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Player < b.Player
+}
+
+func (lb *Leaderboard) position(e Entry) int {
+	return sort.Search(len(lb.order), func(i int) bool {
+		return !ranksBefore(lb.order[i], e)
+	})
+}
+
+// Increment adds delta (it can be negative) to the player's score and returns
+// the new score
+func (lb *Leaderboard) Increment(player string, delta int) int {
+	lb.l.Lock()
+	defer lb.l.Unlock()
+	lb.rollLocked()
+	old, ok := lb.scores[player]
+	if ok {
+		i := lb.position(Entry{Player: player, Score: old})
+		lb.order = append(lb.order[:i], lb.order[i+1:]...)
+	}
+	e := Entry{Player: player, Score: old + delta}
+	i := lb.position(e)
+	lb.order = append(lb.order, Entry{})
+	copy(lb.order[i+1:], lb.order[i:])
+	lb.order[i] = e
+	lb.scores[player] = e.Score
+	return e.Score
+}
+
+func (lb *Leaderboard) Read(player string) (int, bool) {
+	lb.rlock()
+	defer lb.l.RUnlock()
+	score, ok := lb.scores[player]
+	return score, ok
+}
+
+// rankAt returns the rank of the entry in position i, the first player with the
+// same score tells us how many players are strictly better
+func (lb *Leaderboard) rankAt(i int) int {
+	score := lb.order[i].Score
+	first := sort.Search(i, func(j int) bool {
+		return lb.order[j].Score <= score
+	})
+	return first + 1
+}
+
+func (lb *Leaderboard) Rank(player string) (Entry, bool) {
+	lb.rlock()
+	defer lb.l.RUnlock()
+	score, ok := lb.scores[player]
+	if !ok {
+		return Entry{}, false
+	}
+	i := lb.position(Entry{Player: player, Score: score})
+	e := lb.order[i]
+	e.Rank = lb.rankAt(i)
+	return e, true
+}
+
+// entries copies order[from:to] with the ranks filled in, the caller gets its own
+// slice and can keep it after we release the lock
+func (lb *Leaderboard) entries(from, to int) []Entry {
+	from = max(from, 0)
+	to = min(to, len(lb.order))
+	if from >= to {
+		return nil
+	}
+	out := make([]Entry, to-from)
+	copy(out, lb.order[from:to])
+	out[0].Rank = lb.rankAt(from)
+	for i := 1; i < len(out); i++ {
+		if out[i].Score == out[i-1].Score {
+			out[i].Rank = out[i-1].Rank
+		} else {
+			out[i].Rank = from + i + 1
+		}
+	}
+	return out
+}
+
+func (lb *Leaderboard) Top(n int) []Entry {
+	lb.rlock()
+	defer lb.l.RUnlock()
+	return lb.entries(0, n)
+}
+
+// Around returns the player with up to radius players above and below, the page
+// a player sees when they open the leaderboard
+func (lb *Leaderboard) Around(player string, radius int) []Entry {
+	lb.rlock()
+	defer lb.l.RUnlock()
+	score, ok := lb.scores[player]
+	if !ok {
+		return nil
+	}
+	i := lb.position(Entry{Player: player, Score: score})
+	return lb.entries(i-radius, i+radius+1)
+}
+
+func (lb *Leaderboard) Len() int {
+	lb.rlock()
+	defer lb.l.RUnlock()
+	return len(lb.order)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run it with the race detector on, any unprotected access shows up as a
+// DATA RACE report:
+//
+//	go run -race *.go
+
+// failed is set by any check that doesn't hold, main exits with 1 at the end
+var failed bool
+
+// exercise hammers a store from many goroutines at once and checks that no
+// increment got lost, it is the same for every implementation because all of
+// them meet Store
+func exercise(name string, s Store[string, int]) {
+	const workers, rounds = 8, 1000
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(id int) {
+			defer wg.Done()
+			own := "player" + strconv.Itoa(id)
+			for j := 0; j < rounds; j++ {
+				s.Update("total", func(old int, _ bool) int {
+					return old + 1
+				})
+				s.Set(own, j)
+				s.Read("total")
+				s.Range(func(string, int) bool { return true })
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total, _ := s.Read("total")
+	swapped := s.CompareAndSwap("total", total, 0)
+	notSwapped := s.CompareAndSwap("total", total, 0)
+	s.Delete("player0")
+	_, found := s.Read("player0")
+	fmt.Printf("%-8s total=%d (want %d) cas=%v,%v len=%d deleted=%v\n",
+		name, total, workers*rounds, swapped, notSwapped, s.Len(), !found)
+	if total != workers*rounds || !swapped || notSwapped || found {
+		failed = true
+		fmt.Printf("FAIL %s\n", name)
+	}
+}
+
+// testing.Benchmark runs a benchmark outside of go test, it picks b.N for us.
+// The mix is what a scoreboard sees: many reads and a few updates
+func benchmark(s Store[string, int]) testing.BenchmarkResult {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "player" + strconv.Itoa(i)
+		s.Set(keys[i], i)
+	}
+	return testing.Benchmark(func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				key := keys[i%len(keys)]
+				if i%10 == 0 {
+					s.Update(key, func(old int, _ bool) int {
+						return old + 1
+					})
+				} else {
+					s.Read(key)
+				}
+				i++
+			}
+		})
+	})
+}
+
+// fakeClock is moved by hand, so the daily reset happens when we say so
+type fakeClock struct {
+	l sync.Mutex
+	t time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	return fc.t
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.l.Lock()
+	defer fc.l.Unlock()
+	fc.t = fc.t.Add(d)
+}
+
+// While writers move players around, readers check that every Top they get is
+// in order and that the ranks agree with the scores, a torn read would break it
+func demoLeaderboard() {
+	clock := &fakeClock{t: time.Date(2021, 9, 1, 20, 0, 0, 0, time.UTC)}
+	lb := NewLeaderboard(Daily, clock.Now)
+
+	const players, rounds = 50, 200
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	broken := make(chan string, 1)
+	writers.Add(players)
+	for i := 0; i < players; i++ {
+		go func(id int) {
+			defer writers.Done()
+			name := "player" + strconv.Itoa(id)
+			for j := 0; j < rounds; j++ {
+				lb.Increment(name, id%7+1)
+			}
+		}(i)
+	}
+	readers.Add(4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				top := lb.Top(players)
+				for k := 1; k < len(top); k++ {
+					if top[k].Score > top[k-1].Score || top[k].Rank < top[k-1].Rank {
+						select {
+						case broken <- fmt.Sprint("out of order: ", top[k-1], top[k]):
+						default:
+						}
+						return
+					}
+				}
+			}
+		}()
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+	select {
+	case msg := <-broken:
+		failed = true
+		fmt.Println("FAIL", msg)
+	default:
+		fmt.Println("leaderboard reads stayed consistent")
+	}
+
+	fmt.Println("top 3:", lb.Top(3))
+	me, _ := lb.Rank("player10")
+	fmt.Println("player10:", me)
+	fmt.Println("around player10:", lb.Around("player10", 1))
+
+	// four hours later it is a new day and the daily board starts from zero
+	clock.Advance(4 * time.Hour)
+	fmt.Println("after midnight:", lb.Len(), "players")
+	lb.Increment("player10", 5)
+	fmt.Println("top 3:", lb.Top(3))
+}
+
+// One consumer reads every change of a key, another one never reads and only
+// keeps the latest events of a prefix. After canceling, the goroutine count must
+// be back where it started
+func demoWatch() {
+	before := runtime.NumGoroutine()
+	ws := NewWatchableStore[int](NewMutexStore[string, int]())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events := ws.Watch(ctx, "player1", WatchOptions[int]{Buffer: 1, Policy: Block})
+	var dropped int
+	var droppedL sync.Mutex
+	lazy := ws.WatchPrefix(ctx, "player", WatchOptions[int]{
+		Buffer: 2,
+		Policy: DropOldest,
+		OnDrop: func(Event[int]) {
+			droppedL.Lock()
+			dropped++
+			droppedL.Unlock()
+		},
+	})
+
+	var seen []string
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for e := range events {
+			seen = append(seen, fmt.Sprintf("%d->%d", e.Old, e.New))
+		}
+	}()
+	for i := 1; i <= 3; i++ {
+		ws.Update("player1", func(old int, _ bool) int {
+			return old + 10
+		})
+		ws.Set("player2", i)
+	}
+	ws.Delete("player2")
+
+	cancel()
+	<-consumed
+	var last []Event[int]
+	for e := range lazy {
+		last = append(last, e)
+	}
+	fmt.Println("player1 changes:", seen)
+	fmt.Printf("lazy consumer kept %d events (dropped %d), last deleted=%v\n",
+		len(last), dropped, len(last) > 0 && last[len(last)-1].Deleted)
+
+	// the closing goroutines need a moment to be scheduled and exit
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	leaked := runtime.NumGoroutine() - before
+	fmt.Println("goroutines leaked by watchers:", leaked)
+	if leaked > 0 || strings.Join(seen, " ") != "0->10 10->20 20->30" {
+		failed = true
+		fmt.Println("FAIL watch: goroutines leaked or events missing")
+	}
+}
+
+// Crash simulation
+// The program runs itself again as a child that writes to a durable store as fast
+// as it can and prints every key once Set returned, that is, once it was
+// acknowledged. The parent kills it with SIGKILL in the middle of the writes, no
+// deferred function or Close runs, and then restores the store from the same
+// directory. Every key the child printed must be there
+const (
+	crashEnv       = "SCOREBOARD_CRASH_DIR"
+	crashFormatEnv = "SCOREBOARD_CRASH_FORMAT"
+)
+
+func crashChild(dir string, format Format) {
+	ds, err := OpenDurableStore[string, int](NewMutexStore[string, int](), DurableOptions{
+		Dir:      dir,
+		Format:   format,
+		WAL:      true,
+		Interval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if err := ds.Set("key"+strconv.Itoa(i), i); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(i)
+		if i%300 == 0 {
+			// on demand too, so the kill can land in the middle of one
+			if err := ds.Snapshot(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+func demoCrash(format Format) {
+	dir, err := os.MkdirTemp("", "scoreboard")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), crashEnv+"="+dir, crashFormatEnv+"="+strconv.Itoa(int(format)))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	var acked []int
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		i, err := strconv.Atoi(scanner.Text())
+		if err != nil {
+			continue
+		}
+		acked = append(acked, i)
+		if len(acked) == 2000 {
+			cmd.Process.Kill()
+		}
+	}
+	cmd.Wait()
+
+	ds, err := OpenDurableStore[string, int](NewMutexStore[string, int](), DurableOptions{
+		Dir:    dir,
+		Format: format,
+		WAL:    true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	lost := 0
+	for _, i := range acked {
+		if v, ok := ds.Read("key" + strconv.Itoa(i)); !ok || v != i {
+			lost++
+		}
+	}
+	fmt.Printf("crash (%s): %d acknowledged, %d restored, %d lost\n",
+		map[Format]string{JSON: "json", Gob: "gob"}[format], len(acked), ds.Len(), lost)
+	if lost > 0 || len(acked) == 0 {
+		failed = true
+		fmt.Println("FAIL crash: acknowledged writes are missing")
+	}
+	ds.Close()
+}
+
+type namedStore struct {
+	name  string
+	store Store[string, int]
+}
+
+// One of each, the returned func stops the channel actor
+func newStores() ([]namedStore, func()) {
+	cs, done := NewChannelStore[string, int]()
+	return []namedStore{
+		{"channel", cs},
+		{"rwmutex", NewMutexStore[string, int]()},
+		{"sharded", NewShardedStore[string, int](16)},
+	}, done
+}
+
+func main() {
+	if dir := os.Getenv(crashEnv); dir != "" {
+		format, _ := strconv.Atoi(os.Getenv(crashFormatEnv))
+		crashChild(dir, Format(format))
+		return
+	}
+
+	stores, done := newStores()
+	defer done()
+	for _, s := range stores {
+		exercise(s.name, s.store)
+	}
+
+	demoLeaderboard()
+	demoWatch()
+	demoCrash(JSON)
+	demoCrash(Gob)
+
+	// fresh stores, so the benchmarks don't start with the leftovers
+	benchStores, benchDone := newStores()
+	defer benchDone()
+	for _, s := range benchStores {
+		fmt.Printf("%-8s %s\n", s.name, benchmark(s.store))
+	}
+
+	if failed {
+		benchDone()
+		done()
+		os.Exit(1)
+	}
+}
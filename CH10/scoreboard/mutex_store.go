@@ -0,0 +1,77 @@
+package main
+
+import "sync"
+
+// RWMutex version, MutexScoreboardManager but readers share the lock, many
+// goroutines can Read at the same time and only writers wait for each other
+
+type MutexStore[K comparable, V any] struct {
+	l sync.RWMutex
+	m map[K]V
+}
+
+func NewMutexStore[K comparable, V any]() *MutexStore[K, V] {
+	return &MutexStore[K, V]{
+		m: map[K]V{},
+	}
+}
+
+func (ms *MutexStore[K, V]) Read(key K) (V, bool) {
+	ms.l.RLock()
+	defer ms.l.RUnlock()
+	val, ok := ms.m[key]
+	return val, ok
+}
+
+func (ms *MutexStore[K, V]) Set(key K, val V) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	ms.m[key] = val
+}
+
+func (ms *MutexStore[K, V]) Delete(key K) {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	delete(ms.m, key)
+}
+
+func (ms *MutexStore[K, V]) CompareAndSwap(key K, old, new V) bool {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	if cur, ok := ms.m[key]; ok && equal(cur, old) {
+		ms.m[key] = new
+		return true
+	}
+	return false
+}
+
+func (ms *MutexStore[K, V]) Update(key K, fn func(old V, ok bool) V) V {
+	ms.l.Lock()
+	defer ms.l.Unlock()
+	old, ok := ms.m[key]
+	val := fn(old, ok)
+	ms.m[key] = val
+	return val
+}
+
+func (ms *MutexStore[K, V]) Range(fn func(key K, val V) bool) {
+	// copy under the read lock and call fn without it, if fn calls the store
+	// while we hold the lock, a writer in between would deadlock us
+	ms.l.RLock()
+	snapshot := make(map[K]V, len(ms.m))
+	for k, v := range ms.m {
+		snapshot[k] = v
+	}
+	ms.l.RUnlock()
+	for k, v := range snapshot {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (ms *MutexStore[K, V]) Len() int {
+	ms.l.RLock()
+	defer ms.l.RUnlock()
+	return len(ms.m)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// Sharded version, with a single lock every writer waits for every other writer
+// even if they touch different keys. Splitting the map in shards, each with its
+// own RWMutex, lets writers of different shards run at the same time. The price
+// is that Range and Len are not a single point in time, they visit shard by shard
+
+type shard[K comparable, V any] struct {
+	l sync.RWMutex
+	m map[K]V
+}
+
+type ShardedStore[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards []*shard[K, V]
+}
+
+func NewShardedStore[K comparable, V any](shards int) *ShardedStore[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	ss := &ShardedStore[K, V]{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*shard[K, V], shards),
+	}
+	for i := range ss.shards {
+		ss.shards[i] = &shard[K, V]{m: map[K]V{}}
+	}
+	return ss
+}
+
+func (ss *ShardedStore[K, V]) shardFor(key K) *shard[K, V] {
+	return ss.shards[maphash.Comparable(ss.seed, key)%uint64(len(ss.shards))]
+}
+
+func (ss *ShardedStore[K, V]) Read(key K) (V, bool) {
+	s := ss.shardFor(key)
+	s.l.RLock()
+	defer s.l.RUnlock()
+	val, ok := s.m[key]
+	return val, ok
+}
+
+func (ss *ShardedStore[K, V]) Set(key K, val V) {
+	s := ss.shardFor(key)
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.m[key] = val
+}
+
+func (ss *ShardedStore[K, V]) Delete(key K) {
+	s := ss.shardFor(key)
+	s.l.Lock()
+	defer s.l.Unlock()
+	delete(s.m, key)
+}
+
+func (ss *ShardedStore[K, V]) CompareAndSwap(key K, old, new V) bool {
+	s := ss.shardFor(key)
+	s.l.Lock()
+	defer s.l.Unlock()
+	if cur, ok := s.m[key]; ok && equal(cur, old) {
+		s.m[key] = new
+		return true
+	}
+	return false
+}
+
+func (ss *ShardedStore[K, V]) Update(key K, fn func(old V, ok bool) V) V {
+	s := ss.shardFor(key)
+	s.l.Lock()
+	defer s.l.Unlock()
+	old, ok := s.m[key]
+	val := fn(old, ok)
+	s.m[key] = val
+	return val
+}
+
+func (ss *ShardedStore[K, V]) Range(fn func(key K, val V) bool) {
+	for _, s := range ss.shards {
+		s.l.RLock()
+		snapshot := make(map[K]V, len(s.m))
+		for k, v := range s.m {
+			snapshot[k] = v
+		}
+		s.l.RUnlock()
+		for k, v := range snapshot {
+			if !fn(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (ss *ShardedStore[K, V]) Len() int {
+	n := 0
+	for _, s := range ss.shards {
+		s.l.RLock()
+		n += len(s.m)
+		s.l.RUnlock()
+	}
+	return n
+}
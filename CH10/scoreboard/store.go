@@ -0,0 +1,32 @@
+package main
+
+// The scoreboard managers in concurrency8.go only work with map[string]int and only
+// know how to Update and Read. Store is the same idea for any key and value type,
+// with the operations a real program ends up needing. The three implementations
+// (channel actor, RWMutex and sharded locks) meet this interface, so the code that
+// uses a store doesn't care about how it is protected, we can swap them and compare
+
+type Store[K comparable, V any] interface {
+	// Read returns the value for key and whether it was present
+	Read(key K) (V, bool)
+	// Set stores val for key, it is what Update did in the scoreboard managers
+	Set(key K, val V)
+	Delete(key K)
+	// CompareAndSwap stores new only if the current value is old. Like in sync.Map,
+	// old must be of a comparable type, otherwise it panics
+	CompareAndSwap(key K, old, new V) bool
+	// Update runs fn with the current value and stores what it returns, nobody else
+	// can touch the key in between, so read-modify-write cycles are safe
+	Update(key K, fn func(old V, ok bool) V) V
+	// Range calls fn for each pair until it returns false. It works over a copy,
+	// fn can call the store without deadlocking but won't see its own changes
+	Range(fn func(key K, val V) bool)
+	Len() int
+}
+
+// Comparing two values of a type parameter that is only "any" can't be done with ==,
+// converting them to interfaces moves the check to runtime (a panic when V is a
+// slice, map or func)
+func equal[V any](a, b V) bool {
+	return any(a) == any(b)
+}
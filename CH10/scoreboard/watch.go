@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Watching changes
+// Polling with Read means asking again and again if something changed. With Watch
+// the store tells the consumer: it returns a channel that receives an Event every
+// time a key (or any key with a prefix) is written.
+//
+// The channel is buffered, but a consumer can still be slower than the writers,
+// the Policy decides what happens then:
+//   - Block: the writer waits until there is room, nothing is lost but a slow
+//     consumer slows every writer down (backpressure)
+//   - DropNewest / DropOldest: the writer never waits, the event that doesn't fit
+//     or the oldest one in the buffer is thrown away
+//
+// Each subscription has a goroutine that waits for its context, when it is
+// canceled the subscription is removed and the channel closed, so ranging over
+// it ends and no goroutine is left behind
+
+type Policy int
+
+const (
+	Block Policy = iota
+	DropNewest
+	DropOldest
+)
+
+type Event[V any] struct {
+	Key     string
+	Old     V
+	HadOld  bool // false when the key was created by this write
+	New     V
+	Deleted bool // New is the zero value
+}
+
+type WatchOptions[V any] struct {
+	Buffer int // capacity of the channel, 0 is unbuffered
+	Policy Policy
+	OnDrop func(Event[V]) // called with every event that was thrown away
+}
+
+type subscription[V any] struct {
+	ctx   context.Context
+	match func(key string) bool
+	out   chan Event[V]
+	opts  WatchOptions[V]
+}
+
+// WatchableStore wraps a Store with string keys, it is a Store itself, so it can
+// be used wherever a Store is expected (even inside a DurableStore)
+type WatchableStore[V any] struct {
+	// l serializes the writes, the events of a key arrive in the same order as the
+	// writes, and a subscription is never closed while an event is sent to it
+	l     sync.Mutex
+	store Store[string, V]
+	subs  map[*subscription[V]]struct{}
+}
+
+func NewWatchableStore[V any](store Store[string, V]) *WatchableStore[V] {
+	return &WatchableStore[V]{
+		store: store,
+		subs:  map[*subscription[V]]struct{}{},
+	}
+}
+
+// Watch sends the changes of key until ctx is canceled
+func (ws *WatchableStore[V]) Watch(ctx context.Context, key string, opts WatchOptions[V]) <-chan Event[V] {
+	return ws.subscribe(ctx, func(k string) bool {
+		return k == key
+	}, opts)
+}
+
+// WatchPrefix sends the changes of every key that starts with prefix, "" means all
+func (ws *WatchableStore[V]) WatchPrefix(ctx context.Context, prefix string, opts WatchOptions[V]) <-chan Event[V] {
+	return ws.subscribe(ctx, func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	}, opts)
+}
+
+func (ws *WatchableStore[V]) subscribe(ctx context.Context, match func(string) bool, opts WatchOptions[V]) <-chan Event[V] {
+	sub := &subscription[V]{
+		ctx:   ctx,
+		match: match,
+		out:   make(chan Event[V], max(opts.Buffer, 0)),
+		opts:  opts,
+	}
+	ws.l.Lock()
+	ws.subs[sub] = struct{}{}
+	ws.l.Unlock()
+	go func() {
+		<-ctx.Done()
+		// a writer blocked on this subscription also watches ctx, it lets go of
+		// the lock and we can close the channel safely
+		ws.l.Lock()
+		delete(ws.subs, sub)
+		close(sub.out)
+		ws.l.Unlock()
+	}()
+	return sub.out
+}
+
+// publishLocked must be called with l held
+func (ws *WatchableStore[V]) publishLocked(e Event[V]) {
+	for sub := range ws.subs {
+		if sub.ctx.Err() == nil && sub.match(e.Key) {
+			sub.send(e)
+		}
+	}
+}
+
+func (sub *subscription[V]) send(e Event[V]) {
+	switch sub.opts.Policy {
+	case DropNewest:
+		select {
+		case sub.out <- e:
+		default:
+			sub.drop(e)
+		}
+	case DropOldest:
+		if cap(sub.out) == 0 {
+			// there is no buffer to make room in
+			select {
+			case sub.out <- e:
+			default:
+				sub.drop(e)
+			}
+			return
+		}
+		for {
+			select {
+			case sub.out <- e:
+				return
+			default:
+			}
+			// make room, the consumer may read it first and then the loop
+			// simply tries again
+			select {
+			case old := <-sub.out:
+				sub.drop(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.out <- e:
+		case <-sub.ctx.Done():
+		}
+	}
+}
+
+func (sub *subscription[V]) drop(e Event[V]) {
+	if sub.opts.OnDrop != nil {
+		sub.opts.OnDrop(e)
+	}
+}
+
+func (ws *WatchableStore[V]) Read(key string) (V, bool) {
+	return ws.store.Read(key)
+}
+
+func (ws *WatchableStore[V]) Range(fn func(key string, val V) bool) {
+	ws.store.Range(fn)
+}
+
+func (ws *WatchableStore[V]) Len() int {
+	return ws.store.Len()
+}
+
+func (ws *WatchableStore[V]) Set(key string, val V) {
+	ws.l.Lock()
+	defer ws.l.Unlock()
+	old, ok := ws.store.Read(key)
+	ws.store.Set(key, val)
+	ws.publishLocked(Event[V]{Key: key, Old: old, HadOld: ok, New: val})
+}
+
+func (ws *WatchableStore[V]) Delete(key string) {
+	ws.l.Lock()
+	defer ws.l.Unlock()
+	old, ok := ws.store.Read(key)
+	if !ok {
+		return
+	}
+	ws.store.Delete(key)
+	ws.publishLocked(Event[V]{Key: key, Old: old, HadOld: true, Deleted: true})
+}
+
+func (ws *WatchableStore[V]) CompareAndSwap(key string, old, new V) bool {
+	ws.l.Lock()
+	defer ws.l.Unlock()
+	if !ws.store.CompareAndSwap(key, old, new) {
+		return false
+	}
+	ws.publishLocked(Event[V]{Key: key, Old: old, HadOld: true, New: new})
+	return true
+}
+
+func (ws *WatchableStore[V]) Update(key string, fn func(old V, ok bool) V) V {
+	ws.l.Lock()
+	defer ws.l.Unlock()
+	old, ok := ws.store.Read(key)
+	val := fn(old, ok)
+	ws.store.Set(key, val)
+	ws.publishLocked(Event[V]{Key: key, Old: old, HadOld: ok, New: val})
+	return val
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// doSomeWork takes a while, but it listens to its context
+func doSomeWork(ctx context.Context, d time.Duration) (int, error) {
+	select {
+	case <-time.After(d):
+		return 42, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func work(d time.Duration) func(context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		return doSomeWork(ctx, d)
+	}
+}
+
+func main() {
+	ctx := context.Background()
+
+	v, err := WithTimeout(ctx, 50*time.Millisecond, work(10*time.Millisecond))
+	fmt.Println("in time:", v, err)
+
+	_, err = WithTimeout(ctx, 20*time.Millisecond, work(time.Second))
+	var te TimeoutError
+	fmt.Println("too slow:", err, errors.Is(err, ErrTimeout), errors.As(err, &te), te.After)
+
+	// the parent is canceled before the timeout, that is not a timeout
+	pctx, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	_, err = WithTimeout(pctx, time.Second, work(time.Second))
+	fmt.Println("parent canceled:", err, errors.Is(err, ErrTimeout))
+
+	late := make(chan string)
+	_, err = WithTimeoutLate(ctx, 10*time.Millisecond, work(30*time.Millisecond), func(v int, err error) {
+		late <- fmt.Sprint("late result: ", v, " ", err)
+	})
+	fmt.Println("late variant:", err)
+	fmt.Println(<-late)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts without races or leaks
+// timeLimit in concurrency5.go has two problems. The goroutine writes result and
+// err, and timeLimit reads them, the only thing ordering those accesses is the
+// close(done), fine when done wins the select, but the goroutine has no way to
+// know that nobody is waiting anymore. And doSomeWork doesn't know about the
+// timeout, so it keeps working after it. Here:
+//   - fn gets a context that is canceled when the time is up, it can stop
+//   - the result travels through a channel with room for one value, there are no
+//     shared variables and the goroutine can always send it and exit
+
+// ErrTimeout is the sentinel to check with errors.Is, the error returned is a
+// TimeoutError, errors.As gives the duration that was exceeded
+var ErrTimeout = errors.New("work timed out")
+
+type TimeoutError struct {
+	After time.Duration
+}
+
+func (te TimeoutError) Error() string {
+	return fmt.Sprintf("work timed out after %v", te.After)
+}
+
+// Is makes errors.Is(err, ErrTimeout) true, and also errors.Is with
+// context.DeadlineExceeded, code that only knows about contexts still works
+func (te TimeoutError) Is(target error) bool {
+	return target == ErrTimeout || target == context.DeadlineExceeded
+}
+
+type outcome[T any] struct {
+	val T
+	err error
+}
+
+// WithTimeout runs fn and waits at most d for it. If the parent ctx is canceled
+// first, its error is returned, not a TimeoutError
+func WithTimeout[T any](ctx context.Context, d time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, d, TimeoutError{After: d})
+	defer cancel()
+	done := make(chan outcome[T], 1)
+	go func() {
+		val, err := fn(ctx)
+		done <- outcome[T]{val: val, err: err}
+	}()
+	select {
+	case o := <-done:
+		return o.val, o.err
+	case <-ctx.Done():
+		var zero T
+		return zero, context.Cause(ctx)
+	}
+}
+
+// WithTimeoutLate is for work that is worth finishing even if the caller can't
+// wait for it, like filling a cache. After d the caller gets a TimeoutError, but
+// fn is not canceled (only the parent ctx can do that) and its result is given to
+// late when it arrives, from another goroutine
+func WithTimeoutLate[T any](ctx context.Context, d time.Duration, fn func(context.Context) (T, error), late func(T, error)) (T, error) {
+	done := make(chan outcome[T], 1)
+	go func() {
+		val, err := fn(ctx)
+		done <- outcome[T]{val: val, err: err}
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var zero T
+	select {
+	case o := <-done:
+		return o.val, o.err
+	case <-ctx.Done():
+		return zero, context.Cause(ctx)
+	case <-timer.C:
+		go func() {
+			o := <-done
+			late(o.val, o.err)
+		}()
+		return zero, TimeoutError{After: d}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+
+	ast "../../CH7/ast"
+)
+
+// Calculator
+// opMap in func2.go maps an operator to a func(int, int) int. The Calculator keeps
+// that idea, an Arithmetic has a map of binary operators, but the type of the
+// numbers is a type parameter, so the same parser and evaluator work with
+// float64 and with *big.Int (integers of any size, no overflow). The operators
+// return an error too, dividing by zero is not a panic. The tree comes from
+// CH7/ast, the evaluator walks it with a type switch, like walkTree in
+// CH7/interfaces3.go, and parses the numbers itself from the text they were
+// written with, a float64 would round the big integers
+
+type binaryOp[N any] func(a, b N) (N, error)
+
+// Func is a function callable from an expression, like max(1, 2)
+type Func[N any] func(args []N) (N, error)
+
+// NumFormat is how a result is printed, the REPL switches it with :fmt
+type NumFormat int
+
+const (
+	FmtDec NumFormat = iota
+	FmtHex
+	FmtSci
+)
+
+type Arithmetic[N any] struct {
+	Parse  func(lit string) (N, error)
+	Ops    map[string]binaryOp[N] // + - * / % ^
+	Neg    func(N) N
+	Format func(N, NumFormat) string
+}
+
+type funcEntry[N any] struct {
+	arity int // -1 for any number of arguments, at least one
+	fn    Func[N]
+}
+
+type Calculator[N any] struct {
+	arith Arithmetic[N]
+	vars  map[string]N
+	funcs map[string]funcEntry[N]
+}
+
+func NewCalculator[N any](arith Arithmetic[N]) *Calculator[N] {
+	return &Calculator[N]{
+		arith: arith,
+		vars:  map[string]N{},
+		funcs: map[string]funcEntry[N]{},
+	}
+}
+
+// Register adds a function, arity -1 accepts one or more arguments
+func (c *Calculator[N]) Register(name string, arity int, fn Func[N]) {
+	c.funcs[name] = funcEntry[N]{arity: arity, fn: fn}
+}
+
+func (c *Calculator[N]) SetVar(name string, val N) {
+	c.vars[name] = val
+}
+
+func (c *Calculator[N]) Var(name string) (N, bool) {
+	val, ok := c.vars[name]
+	return val, ok
+}
+
+func (c *Calculator[N]) Format(val N, f NumFormat) string {
+	return c.arith.Format(val, f)
+}
+
+// Vars returns the names of the variables, sorted
+func (c *Calculator[N]) Vars() []string {
+	names := make([]string, 0, len(c.vars))
+	for name := range c.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Eval parses and evaluates expr, the errors are *Error with the column
+func (c *Calculator[N]) Eval(expr string) (N, error) {
+	n, err := ast.Parse(expr)
+	if err != nil {
+		var zero N
+		return zero, syntaxError(err)
+	}
+	return c.eval(n)
+}
+
+func (c *Calculator[N]) eval(n ast.Node) (N, error) {
+	var zero N
+	switch n := n.(type) {
+	case ast.Number:
+		val, err := c.arith.Parse(n.Lit)
+		if err != nil {
+			return zero, errorAt(n.Col, "%v", err)
+		}
+		return val, nil
+	case ast.Var:
+		val, ok := c.vars[n.Name]
+		if !ok {
+			return zero, errorAt(n.Col, "unknown variable %s", n.Name)
+		}
+		return val, nil
+	case ast.Unary:
+		x, err := c.eval(n.X)
+		if err != nil {
+			return zero, err
+		}
+		return c.arith.Neg(x), nil
+	case ast.Binary:
+		left, err := c.eval(n.Left)
+		if err != nil {
+			return zero, err
+		}
+		right, err := c.eval(n.Right)
+		if err != nil {
+			return zero, err
+		}
+		op, ok := c.arith.Ops[n.Op.String()]
+		if !ok {
+			return zero, errorAt(n.Col, "unsupported operator %s", n.Op)
+		}
+		val, err := op(left, right)
+		if err != nil {
+			return zero, errorAt(n.Col, "%v", err)
+		}
+		return val, nil
+	case ast.Call:
+		f, ok := c.funcs[n.Func]
+		if !ok {
+			return zero, errorAt(n.Col, "unknown function %s", n.Func)
+		}
+		if (f.arity >= 0 && len(n.Args) != f.arity) || (f.arity < 0 && len(n.Args) == 0) {
+			return zero, errorAt(n.Col, "%s takes %s, got %d", n.Func, arityText(f.arity), len(n.Args))
+		}
+		args := make([]N, len(n.Args))
+		for i, a := range n.Args {
+			var err error
+			if args[i], err = c.eval(a); err != nil {
+				return zero, err
+			}
+		}
+		val, err := f.fn(args)
+		if err != nil {
+			return zero, errorAt(n.Col, "%s: %v", n.Func, err)
+		}
+		return val, nil
+	default:
+		// a new node type the evaluator doesn't know yet
+		return zero, errorAt(n.Column(), "unknown node type %T", n)
+	}
+}
+
+func arityText(arity int) string {
+	switch arity {
+	case -1:
+		return "at least 1 argument"
+	case 1:
+		return "1 argument"
+	default:
+		return fmt.Sprintf("%d arguments", arity)
+	}
+}
+
+var errDivByZero = errors.New("division by zero")
+
+// FloatArithmetic works with float64
+var FloatArithmetic = Arithmetic[float64]{
+	Parse: func(lit string) (float64, error) {
+		v, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", lit)
+		}
+		return v, nil
+	},
+	Ops: map[string]binaryOp[float64]{
+		"+": func(a, b float64) (float64, error) { return a + b, nil },
+		"-": func(a, b float64) (float64, error) { return a - b, nil },
+		"*": func(a, b float64) (float64, error) { return a * b, nil },
+		"/": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivByZero
+			}
+			return a / b, nil
+		},
+		"%": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivByZero
+			}
+			return math.Mod(a, b), nil
+		},
+		"^": func(a, b float64) (float64, error) { return math.Pow(a, b), nil },
+	},
+	Neg: func(a float64) float64 { return -a },
+	Format: func(a float64, f NumFormat) string {
+		switch f {
+		case FmtHex:
+			// whole numbers that fit exactly as 0x1f, the rest as a hex float
+			if a == math.Trunc(a) && math.Abs(a) < 1<<53 {
+				if a < 0 {
+					return "-0x" + strconv.FormatInt(int64(-a), 16)
+				}
+				return "0x" + strconv.FormatInt(int64(a), 16)
+			}
+			return strconv.FormatFloat(a, 'x', -1, 64)
+		case FmtSci:
+			return strconv.FormatFloat(a, 'e', -1, 64)
+		default:
+			return strconv.FormatFloat(a, 'g', -1, 64)
+		}
+	},
+}
+
+// maxResultBits limits ^ in integer mode, about 300000 decimal digits
+const maxResultBits = 1 << 20
+
+// BigIntArithmetic works with integers of any size, / and % truncate like Go's
+var BigIntArithmetic = Arithmetic[*big.Int]{
+	Parse: func(lit string) (*big.Int, error) {
+		v, ok := new(big.Int).SetString(lit, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %q", lit)
+		}
+		return v, nil
+	},
+	Ops: map[string]binaryOp[*big.Int]{
+		"+": func(a, b *big.Int) (*big.Int, error) { return new(big.Int).Add(a, b), nil },
+		"-": func(a, b *big.Int) (*big.Int, error) { return new(big.Int).Sub(a, b), nil },
+		"*": func(a, b *big.Int) (*big.Int, error) { return new(big.Int).Mul(a, b), nil },
+		"/": func(a, b *big.Int) (*big.Int, error) {
+			if b.Sign() == 0 {
+				return nil, errDivByZero
+			}
+			return new(big.Int).Quo(a, b), nil
+		},
+		"%": func(a, b *big.Int) (*big.Int, error) {
+			if b.Sign() == 0 {
+				return nil, errDivByZero
+			}
+			return new(big.Int).Rem(a, b), nil
+		},
+		"^": func(a, b *big.Int) (*big.Int, error) {
+			if b.Sign() < 0 {
+				return nil, errors.New("negative exponent in integer mode")
+			}
+			// 0, 1 and -1 stay small whatever the exponent, anything else has
+			// about a.BitLen()*b bits, 10^10^10 would take the whole memory
+			if a.CmpAbs(big.NewInt(1)) > 0 {
+				if !b.IsInt64() || b.Int64() > maxResultBits/int64(a.BitLen()) {
+					return nil, fmt.Errorf("result too large, more than %d bits", maxResultBits)
+				}
+			}
+			return new(big.Int).Exp(a, b, nil), nil
+		},
+	},
+	Neg: func(a *big.Int) *big.Int { return new(big.Int).Neg(a) },
+	Format: func(a *big.Int, f NumFormat) string {
+		switch f {
+		case FmtHex:
+			if a.Sign() < 0 {
+				return "-0x" + new(big.Int).Neg(a).Text(16)
+			}
+			return "0x" + a.Text(16)
+		case FmtSci:
+			// enough precision for every digit, -1 gives the shortest exact form
+			return new(big.Float).SetPrec(uint(a.BitLen())+1).SetInt(a).Text('e', -1)
+		default:
+			return a.String()
+		}
+	},
+}
+
+// NewFloatCalculator comes with sqrt, abs, min and max registered
+func NewFloatCalculator() *Calculator[float64] {
+	c := NewCalculator(FloatArithmetic)
+	c.Register("sqrt", 1, func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, errors.New("negative argument")
+		}
+		return math.Sqrt(args[0]), nil
+	})
+	c.Register("abs", 1, func(args []float64) (float64, error) {
+		return math.Abs(args[0]), nil
+	})
+	c.Register("min", -1, func(args []float64) (float64, error) {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m, nil
+	})
+	c.Register("max", -1, func(args []float64) (float64, error) {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m, nil
+	})
+	c.SetVar("pi", math.Pi)
+	c.SetVar("e", math.E)
+	return c
+}
+
+// NewBigIntCalculator has the same functions, sqrt is the integer square root
+func NewBigIntCalculator() *Calculator[*big.Int] {
+	c := NewCalculator(BigIntArithmetic)
+	c.Register("sqrt", 1, func(args []*big.Int) (*big.Int, error) {
+		if args[0].Sign() < 0 {
+			return nil, errors.New("negative argument")
+		}
+		return new(big.Int).Sqrt(args[0]), nil
+	})
+	c.Register("abs", 1, func(args []*big.Int) (*big.Int, error) {
+		return new(big.Int).Abs(args[0]), nil
+	})
+	c.Register("min", -1, func(args []*big.Int) (*big.Int, error) {
+		m := args[0]
+		for _, a := range args[1:] {
+			if a.Cmp(m) < 0 {
+				m = a
+			}
+		}
+		return m, nil
+	})
+	c.Register("max", -1, func(args []*big.Int) (*big.Int, error) {
+		m := args[0]
+		for _, a := range args[1:] {
+			if a.Cmp(m) > 0 {
+				m = a
+			}
+		}
+		return m, nil
+	})
+	return c
+}
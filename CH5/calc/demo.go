@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// show prints the result, or the error with a caret under its column
+func show[N any](c *Calculator[N], expr string) {
+	val, err := c.Eval(expr)
+	if err != nil {
+		fmt.Printf("  %s\n", expr)
+		if e, ok := err.(*Error); ok {
+			fmt.Printf("  %s^ %s\n", strings.Repeat(" ", e.Col-1), e.Msg)
+		} else {
+			fmt.Println(" ", err)
+		}
+		return
+	}
+	fmt.Printf("  %s = %s\n", expr, c.Format(val, FmtDec))
+}
+
+// demo runs with -demo
+func demo() {
+	// The expressions of func2.go, without the spaces they don't need, and some
+	// that the old calculator couldn't do
+	fc := NewFloatCalculator()
+	fmt.Println("float:")
+	for _, expr := range []string{
+		"2 + 3", "2 - 3", "2 * 3", "2 / 3", "2 % 3",
+		"two + three", "5",
+		"2 + 3 * 4", "(2 + 3) * 4", "-2^2", "2^3^2", "-(1 - 4) % 2",
+		"max(1, sqrt(16), 3.5) * pi", "2 / (1 - 1)", "sqrt(-1)",
+		"2 + * 3", "(1 + 2", "max()", "3 $ 4",
+	} {
+		show(fc, expr)
+	}
+
+	ic := NewBigIntCalculator()
+	fmt.Println("big integer:")
+	for _, expr := range []string{
+		"2^100", "-7 / 2", "-7 % 2", "sqrt(10^40 + 1)", "1.5 + 1", "2^-1",
+	} {
+		show(ic, expr)
+	}
+}
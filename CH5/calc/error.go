@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	ast "../../CH7/ast"
+)
+
+// Errors
+// The expressions are parsed by CH7/ast, the same parser as the AST package, so
+// the two can't drift apart. Its syntax errors and the ones of the evaluator are
+// both an *Error here, the REPL only needs to know one type to point at a column
+
+// Error is the error of anything that went wrong in an expression, Col says where
+type Error struct {
+	Col int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Col, e.Msg)
+}
+
+func errorAt(col int, format string, args ...any) *Error {
+	return &Error{Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// syntaxError turns the errors of the parser into ours, with the same column
+func syntaxError(err error) error {
+	var se *ast.SyntaxError
+	if errors.As(err, &se) {
+		return errorAt(se.Col, "%s", se.Msg)
+	}
+	return err
+}
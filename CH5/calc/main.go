@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// calc [-int] [-f file] [-demo]
+// Without -f it reads stdin, interactive with a prompt when stdin is a terminal
+// and like a batch file when it's a pipe: echo "2^10" | calc
+// The parser is imported from CH7/ast with a relative path, there is no go.mod
+// in this tree, so it builds in GOPATH mode: GO111MODULE=off go build
+
+func main() {
+	intMode := flag.Bool("int", false, "use integers of any size instead of float64")
+	file := flag.String("f", "", "run the lines of a file and print the results")
+	runDemo := flag.Bool("demo", false, "run the expressions of func2.go and exit")
+	flag.Parse()
+
+	if *runDemo {
+		demo()
+		return
+	}
+	var code int
+	if *intMode {
+		code = run(NewBigIntCalculator(), *file)
+	} else {
+		code = run(NewFloatCalculator(), *file)
+	}
+	os.Exit(code)
+}
+
+func run[N any](c *Calculator[N], file string) int {
+	var in io.Reader = os.Stdin
+	name := "stdin"
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in, name = f, file
+	} else if isTerminal(os.Stdin) {
+		interactive(c, os.Stdin, os.Stdout)
+		return 0
+	}
+
+	failed, err := batch(c, name, in, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
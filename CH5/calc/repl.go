@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	ast "../../CH7/ast"
+)
+
+// REPL
+// One line at a time: an expression prints its value (and keeps it in ans),
+// "x = 3 * 4" saves a variable, and the lines starting with ':' are commands.
+// The same loop runs the interactive mode and the batch mode, the only
+// differences are the prompt and how the errors are shown
+
+const help = `  expr            evaluate, the result is saved in ans
+  name = expr     assign a variable
+  !n              run line n of the history again
+  :vars           list the variables
+  :fmt hex|dec|sci  change how the results are printed
+  :history        list the previous lines
+  :help           this
+  :quit           exit`
+
+var errQuit = errors.New("quit")
+
+type session[N any] struct {
+	calc    *Calculator[N]
+	format  NumFormat
+	history []string
+	out     io.Writer
+	prompt  string // "" in batch mode
+}
+
+// exec runs one line, the errors are for the caller to print, the session keeps
+// going. The trimmed copy is only for telling what kind of line it is, Eval gets
+// the line as typed so the columns of the errors match it
+func (s *session[N]) exec(line string) error {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		return nil
+	case strings.HasPrefix(trimmed, ":"):
+		return s.command(strings.Fields(trimmed[1:]))
+	case strings.HasPrefix(trimmed, "!"):
+		n, err := strconv.Atoi(trimmed[1:])
+		if err != nil || n < 1 || n > len(s.history) {
+			return fmt.Errorf("no line %s in the history", trimmed[1:])
+		}
+		// echoed after the prompt, like it was typed, the caret counts on it
+		line = s.history[n-1]
+		fmt.Fprintln(s.out, s.prompt+line)
+	}
+	s.history = append(s.history, line)
+
+	name, expr, err := splitAssign(line)
+	if err != nil {
+		return err
+	}
+	val, err := s.calc.Eval(expr)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = "ans"
+		fmt.Fprintln(s.out, s.calc.Format(val, s.format))
+	} else {
+		fmt.Fprintf(s.out, "%s = %s\n", name, s.calc.Format(val, s.format))
+	}
+	s.calc.SetVar(name, val)
+	return nil
+}
+
+func (s *session[N]) command(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command, try :help")
+	}
+	switch args[0] {
+	case "vars":
+		for _, name := range s.calc.Vars() {
+			val, _ := s.calc.Var(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, s.calc.Format(val, s.format))
+		}
+	case "fmt":
+		if len(args) != 2 {
+			return errors.New("usage: :fmt hex|dec|sci")
+		}
+		switch args[1] {
+		case "dec":
+			s.format = FmtDec
+		case "hex":
+			s.format = FmtHex
+		case "sci":
+			s.format = FmtSci
+		default:
+			return fmt.Errorf("unknown format %q, want hex, dec or sci", args[1])
+		}
+	case "history":
+		for i, line := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, line)
+		}
+	case "help":
+		fmt.Fprintln(s.out, help)
+	case "quit", "q":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command :%s, try :help", args[0])
+	}
+	return nil
+}
+
+// splitAssign splits "x = 3 * 4" in "x" and the expression. The name and the
+// '=' are replaced by spaces instead of cut, so the columns of the errors
+// still match the line the user typed
+func splitAssign(line string) (name, expr string, err error) {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", line, nil
+	}
+	name = strings.TrimSpace(line[:i])
+	if n, err := ast.Parse(name); err != nil || !isVar(n) {
+		indent := utf8.RuneCountInString(line[:i]) - utf8.RuneCountInString(strings.TrimLeftFunc(line[:i], unicode.IsSpace))
+		return "", "", errorAt(indent+1, "can only assign to a variable name, not %q", name)
+	}
+	if strings.IndexByte(line[i+1:], '=') >= 0 {
+		return "", "", errorAt(utf8.RuneCountInString(line[:i+1])+strings.IndexByte(line[i+1:], '=')+1, "only one '=' per line")
+	}
+	return name, strings.Repeat(" ", utf8.RuneCountInString(line[:i+1])) + line[i+1:], nil
+}
+
+func isVar(n ast.Node) bool {
+	_, ok := n.(ast.Var)
+	return ok
+}
+
+// interactive prints a prompt and points at the errors with a caret
+func interactive[N any](c *Calculator[N], in io.Reader, out io.Writer) {
+	s := &session[N]{calc: c, out: out, prompt: "> "}
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintln(out, "calc, :help for the commands")
+	for {
+		fmt.Fprint(out, s.prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		err := s.exec(scanner.Text())
+		var exprErr *Error
+		switch {
+		case err == nil:
+		case errors.Is(err, errQuit):
+			return
+		case errors.As(err, &exprErr):
+			fmt.Fprintf(out, "%s^ %s\n", strings.Repeat(" ", len(s.prompt)+exprErr.Col-1), exprErr.Msg)
+		default:
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+// batch runs every line of in, the results go to out and the errors to errOut
+// with their line number, so a script can tell them apart. It returns how many
+// lines failed
+func batch[N any](c *Calculator[N], name string, in io.Reader, out, errOut io.Writer) (int, error) {
+	s := &session[N]{calc: c, out: out}
+	scanner := bufio.NewScanner(in)
+	failed := 0
+	for n := 1; scanner.Scan(); n++ {
+		err := s.exec(scanner.Text())
+		if errors.Is(err, errQuit) {
+			break
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(errOut, "%s:%d: %v\n", name, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return failed, fmt.Errorf("in batch: %w", err)
+	}
+	return failed, nil
+}
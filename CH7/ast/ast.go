@@ -0,0 +1,180 @@
+// Package ast is the expression tree of interfaces3.go as a package of its own,
+// with a parser, a printer, an evaluator and a simplifier. CH5/calc parses with
+// it too. There is no go.mod in this tree, the importers use a relative path and
+// run in GOPATH mode (GO111MODULE=off), demo/main.go shows how
+package ast
+
+import (
+	"errors"
+	"math"
+)
+
+// AST
+// interfaces3.go sketches walkTree over a treeNode, its val is a number or an
+// operator and a type switch tells them apart. Here every kind of node is its
+// own type, and the Node interface only has an unexported method besides
+// Column, so no other package can add a node the type switches don't know
+// about (they still have a default case, like walkTree)
+//
+// Col is where the node was in the source, 1-based and in runes, so whoever
+// evaluates the tree can point at the part that failed. It is 0 for the nodes
+// that were built and not parsed, and it is not part of the expression: Equal
+// doesn't look at it
+
+type Node interface {
+	Column() int
+	isNode()
+}
+
+// Number is a constant, 2 or 0.5. Lit is the number as it was written, a
+// caller that doesn't work with float64 (CH5/calc with big integers) parses it
+// again, without the rounding of Value. It is empty when the number wasn't parsed
+type Number struct {
+	Value float64
+	Lit   string
+	Col   int
+}
+
+// Var is a name whose value comes from the Env when evaluating, x
+type Var struct {
+	Name string
+	Col  int
+}
+
+// Unary is -X, minus is the only unary operator
+type Unary struct {
+	Op  Op
+	X   Node
+	Col int // of the operator
+}
+
+// Binary is Left Op Right, 2 + 3
+type Binary struct {
+	Op          Op
+	Left, Right Node
+	Col         int // of the operator, 1 / 0 fails at the '/'
+}
+
+// Call is a function call, max(1, x)
+type Call struct {
+	Func string
+	Args []Node
+	Col  int // of the name
+}
+
+func (n Number) Column() int { return n.Col }
+func (n Var) Column() int    { return n.Col }
+func (n Unary) Column() int  { return n.Col }
+func (n Binary) Column() int { return n.Col }
+func (n Call) Column() int   { return n.Col }
+
+func (Number) isNode() {}
+func (Var) isNode()    {}
+func (Unary) isNode()  {}
+func (Binary) isNode() {}
+func (Call) isNode()   {}
+
+// Equal reports if a and b are the same expression, the columns and the way
+// the numbers were written don't count, 0.5 and .5 are the same Number
+func Equal(a, b Node) bool {
+	switch a := a.(type) {
+	case Number:
+		b, ok := b.(Number)
+		return ok && (a.Value == b.Value && math.Signbit(a.Value) == math.Signbit(b.Value) ||
+			math.IsNaN(a.Value) && math.IsNaN(b.Value))
+	case Var:
+		b, ok := b.(Var)
+		return ok && a.Name == b.Name
+	case Unary:
+		b, ok := b.(Unary)
+		return ok && a.Op == b.Op && Equal(a.X, b.X)
+	case Binary:
+		b, ok := b.(Binary)
+		return ok && a.Op == b.Op && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+	case Call:
+		b, ok := b.(Call)
+		if !ok || a.Func != b.Func || len(a.Args) != len(b.Args) {
+			return false
+		}
+		for i := range a.Args {
+			if !Equal(a.Args[i], b.Args[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+type Op byte
+
+const (
+	Add Op = '+'
+	Sub Op = '-'
+	Mul Op = '*'
+	Div Op = '/'
+	Mod Op = '%'
+	Pow Op = '^'
+	Neg Op = '-' // only in Unary
+)
+
+func (op Op) String() string {
+	return string(op)
+}
+
+// The precedence levels, the parser and the printer both use them so what
+// one writes the other reads back the same
+const (
+	precAdd   = 1 // + -
+	precMul   = 2 // * / %
+	precUnary = 3 // -x
+	precPow   = 4 // ^
+	precAtom  = 5 // numbers, vars, calls
+)
+
+type opInfo struct {
+	prec       int
+	rightAssoc bool
+	apply      func(a, b float64) (float64, error)
+}
+
+var errDivByZero = errors.New("division by zero")
+
+var binaryOps = map[Op]opInfo{
+	Add: {precAdd, false, func(a, b float64) (float64, error) { return a + b, nil }},
+	Sub: {precAdd, false, func(a, b float64) (float64, error) { return a - b, nil }},
+	Mul: {precMul, false, func(a, b float64) (float64, error) { return a * b, nil }},
+	Div: {precMul, false, func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errDivByZero
+		}
+		return a / b, nil
+	}},
+	Mod: {precMul, false, func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errDivByZero
+		}
+		return math.Mod(a, b), nil
+	}},
+	// 2^3^2 is 2^(3^2)
+	Pow: {precPow, true, func(a, b float64) (float64, error) { return math.Pow(a, b), nil }},
+}
+
+// precedence of a node when it's printed, a negative number is written with a
+// '-' in front so it binds like a Unary
+func precedence(n Node) int {
+	switch n := n.(type) {
+	case Number:
+		if math.Signbit(n.Value) {
+			return precUnary
+		}
+		return precAtom
+	case Unary:
+		return precUnary
+	case Binary:
+		return binaryOps[n.Op].prec
+	default:
+		return precAtom
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+
+	ast ".."
+)
+
+// The repo doesn't have test files, so the checks run here: the examples are
+// parsed, printed, simplified and evaluated, then a few thousand random trees
+// check that Parse(String(tree)) gives the tree back and that Simplify doesn't
+// change the value
+
+var funcs = map[string]ast.Func{
+	"max": func(args ...float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("max needs at least one argument")
+		}
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m, nil
+	},
+	"sqrt": func(args ...float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("sqrt takes 1 argument, got %d", len(args))
+		}
+		if args[0] < 0 {
+			return 0, errors.New("negative argument")
+		}
+		return math.Sqrt(args[0]), nil
+	},
+}
+
+func main() {
+	env := ast.Env{Vars: map[string]float64{"x": 2, "y": -3}, Funcs: funcs}
+
+	for _, src := range []string{
+		"2 + 3 * 4",
+		"((2 + 3)) * 4",
+		"1 - (2 - 3)",
+		"(1 - 2) - 3",
+		"2 ^ (3 ^ 2)",
+		"(2 ^ 3) ^ 2",
+		"-2 ^ 2",
+		"(-2) ^ 2",
+		"2 ^ -x",
+		"x * 1 + 0 * (y - 0)",
+		"2 * 3 + x",
+		"0 - -(x)",
+		"max(1, sqrt(16), x) / (1 + 1)",
+		"sqrt(-1) + x",
+		"1 / 0",
+		"2 + * 3",
+		"max(1, 2",
+	} {
+		n, err := ast.Parse(src)
+		var syntaxErr *ast.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			fmt.Printf("%-32s\n%s^ %s\n", src, strings.Repeat(" ", syntaxErr.Col-1), syntaxErr.Msg)
+			continue
+		}
+		simple := ast.Simplify(n, funcs)
+		val, err := ast.Eval(n, env)
+		if err != nil {
+			fmt.Printf("%-32s -> %-20s -> %-16s error: %v\n", src, ast.String(n), ast.String(simple), err)
+			continue
+		}
+		fmt.Printf("%-32s -> %-20s -> %-16s = %g\n", src, ast.String(n), ast.String(simple), val)
+	}
+
+	// Random trees, with the same seed every run
+	r := rand.New(rand.NewSource(1))
+	const trees = 5000
+	roundTrips, simplified := 0, 0
+	for i := 0; i < trees; i++ {
+		n := randomNode(r, 5)
+		back, err := ast.Parse(ast.String(n))
+		if err != nil || !ast.Equal(back, n) {
+			fmt.Printf("round trip failed for %#v\n  printed %s\n  parsed  %#v, %v\n", n, ast.String(n), back, err)
+			continue
+		}
+		roundTrips++
+
+		want, wantErr := ast.Eval(n, env)
+		got, gotErr := ast.Eval(ast.Simplify(n, funcs), env)
+		same := (wantErr == nil) == (gotErr == nil) &&
+			(wantErr != nil || want == got || math.IsNaN(want) && math.IsNaN(got) ||
+				math.Abs(want-got) <= 1e-9*math.Max(math.Abs(want), 1))
+		if !same {
+			fmt.Printf("simplify changed %s\n  to %s\n  %v, %v != %v, %v\n", ast.String(n), ast.String(ast.Simplify(n, funcs)), want, wantErr, got, gotErr)
+			continue
+		}
+		simplified++
+	}
+	fmt.Printf("round trip: %d/%d, simplify keeps the value: %d/%d\n", roundTrips, trees, simplified, trees)
+	if roundTrips != trees || simplified != trees {
+		os.Exit(1)
+	}
+}
+
+func randomNode(r *rand.Rand, depth int) ast.Node {
+	if depth == 0 || r.Intn(4) == 0 {
+		switch r.Intn(4) {
+		case 0:
+			return ast.Var{Name: []string{"x", "y"}[r.Intn(2)]}
+		case 1:
+			// negative, big and small numbers, the printer writes them with exponents
+			return ast.Number{Value: []float64{-2, 1e21, 0.5e-9, -0.25}[r.Intn(4)]}
+		default:
+			return ast.Number{Value: float64(r.Intn(4))}
+		}
+	}
+	switch r.Intn(6) {
+	case 0:
+		return ast.Unary{Op: ast.Neg, X: randomNode(r, depth-1)}
+	case 1:
+		args := make([]ast.Node, 1+r.Intn(2))
+		for i := range args {
+			args[i] = randomNode(r, depth-1)
+		}
+		if len(args) == 1 {
+			return ast.Call{Func: "sqrt", Args: args}
+		}
+		return ast.Call{Func: "max", Args: args}
+	default:
+		ops := []ast.Op{ast.Add, ast.Sub, ast.Mul, ast.Div, ast.Mod, ast.Pow}
+		return ast.Binary{Op: ops[r.Intn(len(ops))], Left: randomNode(r, depth-1), Right: randomNode(r, depth-1)}
+	}
+}
@@ -0,0 +1,86 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Func is a function an expression can call, it gets the values of the arguments
+type Func func(args ...float64) (float64, error)
+
+// Env has the values of the variables and the functions for Eval
+type Env struct {
+	Vars  map[string]float64
+	Funcs map[string]Func
+}
+
+// EvalError says which node failed
+type EvalError struct {
+	Node Node
+	Err  error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("in %s: %v", String(e.Node), e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// Eval is walkTree for the whole AST
+func Eval(n Node, env Env) (float64, error) {
+	switch n := n.(type) {
+	case Number:
+		return n.Value, nil
+	case Var:
+		val, ok := env.Vars[n.Name]
+		if !ok {
+			return 0, &EvalError{Node: n, Err: fmt.Errorf("unknown variable %s", n.Name)}
+		}
+		return val, nil
+	case Unary:
+		x, err := Eval(n.X, env)
+		if err != nil {
+			return 0, err
+		}
+		return -x, nil
+	case Binary:
+		op, ok := binaryOps[n.Op]
+		if !ok {
+			return 0, &EvalError{Node: n, Err: fmt.Errorf("unknown operator %s", n.Op)}
+		}
+		left, err := Eval(n.Left, env)
+		if err != nil {
+			return 0, err
+		}
+		right, err := Eval(n.Right, env)
+		if err != nil {
+			return 0, err
+		}
+		val, err := op.apply(left, right)
+		if err != nil {
+			return 0, &EvalError{Node: n, Err: err}
+		}
+		return val, nil
+	case Call:
+		f, ok := env.Funcs[n.Func]
+		if !ok {
+			return 0, &EvalError{Node: n, Err: fmt.Errorf("unknown function %s", n.Func)}
+		}
+		args := make([]float64, len(n.Args))
+		for i, arg := range n.Args {
+			var err error
+			if args[i], err = Eval(arg, env); err != nil {
+				return 0, err
+			}
+		}
+		val, err := f(args...)
+		if err != nil {
+			return 0, &EvalError{Node: n, Err: err}
+		}
+		return val, nil
+	default:
+		// If a new Node type is defined, but Eval wasn't updated to process it, this detects it
+		return 0, fmt.Errorf("unknown node type %T", n)
+	}
+}
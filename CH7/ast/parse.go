@@ -0,0 +1,231 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Parser
+// Precedence climbing over the binaryOps table: parse an operand, then keep
+// taking operators that bind at least as tight as minPrec. A left associative
+// operator parses its right side one level higher, so 1-2-3 stops before the
+// second '-', a right associative one (^) at the same level
+//
+// A '-' right before a number is part of the number, -2 is Number{-2}, unless
+// a ^ follows, -2^2 is -(2^2)
+
+// SyntaxError points at the column, 1-based, where the expression went wrong
+type SyntaxError struct {
+	Col int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Col, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokPunct // operators, parentheses and commas
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r, col := runes[i], i+1
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsDigit(r) || r == '.':
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// exponent, the printer writes 1e+21
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				j := i + 1
+				if j < len(runes) && (runes[j] == '+' || runes[j] == '-') {
+					j++
+				}
+				if j < len(runes) && unicode.IsDigit(runes[j]) {
+					for i = j; i < len(runes) && unicode.IsDigit(runes[i]); i++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), col})
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i]), col})
+			continue
+		}
+		switch r {
+		case '+', '-', '*', '/', '%', '^', '(', ')', ',':
+			tokens = append(tokens, token{tokPunct, string(r), col})
+			i++
+		default:
+			return nil, &SyntaxError{Col: col, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, col: len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse reads an expression like "max(1, x) * -2 ^ 3"
+func Parse(src string) (Node, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.expr(precAdd)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(0); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return n, nil
+}
+
+func (p *parser) peek(ahead int) token {
+	if p.pos+ahead >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+ahead]
+}
+
+func (p *parser) next() token {
+	t := p.peek(0)
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(t token, text string) bool {
+	return t.kind == tokPunct && t.text == text
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &SyntaxError{Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expr(minPrec int) (Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek(0)
+		if t.kind != tokPunct || len(t.text) != 1 {
+			return left, nil
+		}
+		op := Op(t.text[0])
+		info, ok := binaryOps[op]
+		if !ok || info.prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		next := info.prec + 1
+		if info.rightAssoc {
+			next = info.prec
+		}
+		right, err := p.expr(next)
+		if err != nil {
+			return nil, err
+		}
+		left = Binary{Op: op, Left: left, Right: right, Col: t.col}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	if !p.is(p.peek(0), "-") {
+		return p.primary()
+	}
+	minus := p.next()
+	if num := p.peek(0); num.kind == tokNumber && !p.is(p.peek(1), "^") {
+		p.next()
+		return p.number(token{tokNumber, "-" + num.text, minus.col})
+	}
+	// only ^ binds tighter than the minus
+	x, err := p.expr(precPow)
+	if err != nil {
+		return nil, err
+	}
+	return Unary{Op: Neg, X: x, Col: minus.col}, nil
+}
+
+// A number bigger than a float64 can hold (1e999, or an integer of 400 digits)
+// is still a number, Value is ±Inf and Lit has it all
+func (p *parser) number(t token) (Node, error) {
+	v, err := strconv.ParseFloat(t.text, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, p.errorf(t, "invalid number %q", t.text)
+	}
+	return Number{Value: v, Lit: t.text, Col: t.col}, nil
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		return p.number(t)
+	case t.kind == tokIdent && !p.is(p.peek(0), "("):
+		return Var{Name: t.text, Col: t.col}, nil
+	case t.kind == tokIdent:
+		p.next()
+		call := Call{Func: t.text, Col: t.col}
+		if p.is(p.peek(0), ")") {
+			p.next()
+			return call, nil
+		}
+		for {
+			arg, err := p.expr(precAdd)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			sep := p.next()
+			if p.is(sep, ")") {
+				return call, nil
+			}
+			if !p.is(sep, ",") {
+				return nil, p.errorf(sep, "expected \",\" or \")\" in call to %s", t.text)
+			}
+		}
+	case p.is(t, "("):
+		n, err := p.expr(precAdd)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); !p.is(closing, ")") {
+			return nil, p.errorf(closing, "expected \")\" to close the one at column %d", t.col)
+		}
+		return n, nil
+	case t.kind == tokEOF:
+		return nil, p.errorf(t, "unexpected end of expression")
+	default:
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+}
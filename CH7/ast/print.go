@@ -0,0 +1,71 @@
+package ast
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Printer
+// String writes the expression back with only the parentheses it needs: a
+// child gets them when it binds looser than its parent, or as tight but on the
+// wrong side, 1-(2-3) needs them and (1-2)-3 doesn't. Parsing the result gives
+// an Equal tree again
+
+func String(n Node) string {
+	var b strings.Builder
+	write(&b, n)
+	return b.String()
+}
+
+func write(b *strings.Builder, n Node) {
+	switch n := n.(type) {
+	case Number:
+		b.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+	case Var:
+		b.WriteString(n.Name)
+	case Unary:
+		b.WriteString(n.Op.String())
+		// "- -x" and not "--x", in case the language ever gets a -- operator
+		if precedence(n.X) == precUnary {
+			b.WriteByte(' ')
+		}
+		// the parser reads -2 as the Number -2, so the Unary of a
+		// positive Number needs them to be read back as a Unary
+		num, isNum := n.X.(Number)
+		writeChild(b, n.X, precedence(n.X) < precUnary || (isNum && !math.Signbit(num.Value)))
+	case Binary:
+		info := binaryOps[n.Op]
+		lp, rp := precedence(n.Left), precedence(n.Right)
+		writeChild(b, n.Left, lp < info.prec || (lp == info.prec && info.rightAssoc))
+		b.WriteString(" " + n.Op.String() + " ")
+		// the right side of ^ is parsed as a unary, 2 ^ -3 doesn't need them
+		needs := rp < info.prec || (rp == info.prec && !info.rightAssoc)
+		if n.Op == Pow && rp == precUnary {
+			needs = false
+		}
+		writeChild(b, n.Right, needs)
+	case Call:
+		b.WriteString(n.Func)
+		b.WriteByte('(')
+		for i, arg := range n.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			write(b, arg)
+		}
+		b.WriteByte(')')
+	default:
+		b.WriteString("<unknown node>")
+	}
+}
+
+func writeChild(b *strings.Builder, n Node, parens bool) {
+	if parens {
+		b.WriteByte('(')
+	}
+	write(b, n)
+	if parens {
+		b.WriteByte(')')
+	}
+}
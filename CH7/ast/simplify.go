@@ -0,0 +1,87 @@
+package ast
+
+import (
+	"math"
+)
+
+// Constant folding
+// Simplify evaluates every part of the tree that doesn't depend on a variable,
+// 2 * 3 + x is 6 + x, and removes the operations that do nothing, x * 1 is x.
+// A part whose evaluation fails (1 / 0) or isn't a finite number stays as it
+// is, so Eval still reports the error. funcs are the functions that can be
+// called while folding, they should be pure, nil folds no calls
+
+func Simplify(n Node, funcs map[string]Func) Node {
+	switch n := n.(type) {
+	case Unary:
+		return negate(Simplify(n.X, funcs))
+	case Binary:
+		left, right := Simplify(n.Left, funcs), Simplify(n.Right, funcs)
+		l, lok := left.(Number)
+		r, rok := right.(Number)
+		if lok && rok {
+			if op, ok := binaryOps[n.Op]; ok {
+				if v, err := op.apply(l.Value, r.Value); err == nil && isFinite(v) {
+					return Number{Value: v}
+				}
+			}
+		}
+		if id, ok := identity(n.Op, left, right, l, lok, r, rok); ok {
+			return id
+		}
+		return Binary{Op: n.Op, Left: left, Right: right}
+	case Call:
+		args := make([]Node, len(n.Args))
+		vals := make([]float64, len(n.Args))
+		constant := true
+		for i, arg := range n.Args {
+			args[i] = Simplify(arg, funcs)
+			num, ok := args[i].(Number)
+			vals[i], constant = num.Value, constant && ok
+		}
+		if f, ok := funcs[n.Func]; ok && constant {
+			if v, err := f(vals...); err == nil && isFinite(v) {
+				return Number{Value: v}
+			}
+		}
+		return Call{Func: n.Func, Args: args}
+	default:
+		// Number, Var, and anything new is left as it is
+		return n
+	}
+}
+
+// identity removes the operations with 0 and 1 that don't change the other side.
+// Strictly -0 + 0 is 0 and not -0, a difference only something like 1 / x can
+// see, it's ignored
+func identity(op Op, left, right Node, l Number, lok bool, r Number, rok bool) (Node, bool) {
+	switch {
+	case op == Add && rok && r.Value == 0, op == Sub && rok && r.Value == 0:
+		return left, true
+	case op == Add && lok && l.Value == 0:
+		return right, true
+	case op == Sub && lok && l.Value == 0: // 0 - x is -x
+		return negate(right), true
+	case op == Mul && rok && r.Value == 1, op == Div && rok && r.Value == 1, op == Pow && rok && r.Value == 1:
+		return left, true
+	case op == Mul && lok && l.Value == 1:
+		return right, true
+	}
+	// x * 0 isn't 0 when x is Inf or NaN, and x ^ 0 would hide an error in x
+	// like sqrt(-1) ^ 0, so they stay
+	return nil, false
+}
+
+func negate(x Node) Node {
+	switch x := x.(type) {
+	case Number:
+		return Number{Value: -x.Value}
+	case Unary: // - -x is x
+		return x.X
+	}
+	return Unary{Op: Neg, X: x}
+}
+
+func isFinite(v float64) bool {
+	return !math.IsInf(v, 0) && !math.IsNaN(v)
+}
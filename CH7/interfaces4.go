@@ -1,9 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	errs "../CH8/errs"
 )
 
 // Function types are a bridge to interfaces
@@ -41,7 +54,8 @@ func LogOutput(message string) {
 
 // Data store
 type SimpleDataStore struct {
-	userData map[string]string
+	userData   map[string]string
+	userGender map[string]string
 }
 
 func (sds SimpleDataStore) UserNameForID(userID string) (string, bool) {
@@ -50,6 +64,13 @@ func (sds SimpleDataStore) UserNameForID(userID string) (string, bool) {
 	// This method meets the interface DataStore
 }
 
+// Some languages change the greeting depending on who is greeted, the store
+// knows it, but not every DataStore has to, so it is an optional interface
+func (sds SimpleDataStore) UserGenderForID(userID string) (string, bool) {
+	gender, ok := sds.userGender[userID]
+	return gender, ok
+}
+
 // Factory function to create an instance of SimpleDataStore
 func NewSimpleDataStore() SimpleDataStore {
 	return SimpleDataStore{
@@ -58,6 +79,11 @@ func NewSimpleDataStore() SimpleDataStore {
 			"2": "Bob",
 			"3": "Pat",
 		},
+		userGender: map[string]string{
+			"1": "male",
+			"2": "male",
+			"3": "female",
+		},
 	}
 }
 
@@ -72,6 +98,12 @@ type Logger interface {
 	Log(message string)
 }
 
+// Optional interface, checked with a type assertion, a DataStore that doesn't
+// know the gender of its users still works, the neutral template is used
+type GenderStore interface {
+	UserGenderForID(userID string) (string, bool)
+}
+
 // To make our LogOutput function meet this interface, we define a function type with a method on it
 
 type LoggerAdapter func(message string)
@@ -85,41 +117,49 @@ func (lg LoggerAdapter) Log(message string) {
 type SimpleLogic struct {
 	// interface fields, no mention of concrete types, so, no dependency on them. No problrm
 	// if we later swap in new implementationa from an entirely different provider
-	l  Logger
-	ds DataStore
+	l       Logger
+	ds      DataStore
+	catalog *Catalog
 }
 
-func (sl SimpleLogic) SayHello(userID string) (string, error) {
+// The greetings are not hard-coded anymore, they live in the message catalog and
+// the caller says in which locales (most preferred first) it wants the answer
+func (sl SimpleLogic) SayHello(userID string, locales []string) (string, error) {
 	sl.l.Log("in SayHello for " + userID)
-	name, ok := sl.ds.UserNameForID(userID)
-	if !ok {
-		return "", errors.New("unknown user")
-	}
-	return "Hello, " + name, nil
+	return sl.greet("hello", userID, locales)
 }
 
-func (sl SimpleLogic) SayGoodbye(userID string) (string, error) {
+func (sl SimpleLogic) SayGoodbye(userID string, locales []string) (string, error) {
 	sl.l.Log("in SayGoodbye for " + userID)
+	return sl.greet("goodbye", userID, locales)
+}
+
+func (sl SimpleLogic) greet(key, userID string, locales []string) (string, error) {
 	name, ok := sl.ds.UserNameForID(userID)
 	if !ok {
-		return "", errors.New("unknown user")
+		return "", errs.New(errs.NotFound, "%s", sl.catalog.Message(locales, "unknown_user", MessageArgs{}))
+	}
+	args := MessageArgs{Name: name, Count: 1}
+	if gs, ok := sl.ds.(GenderStore); ok {
+		args.Gender, _ = gs.UserGenderForID(userID)
 	}
-	return "Goodbye, " + name, nil
+	return sl.catalog.Message(locales, key, args), nil
 }
 
 // When we want a SimpleLogic instance, we call a factory function
-func NewSimpleLogic(l Logger, ds DataStore) SimpleLogic {
+func NewSimpleLogic(l Logger, ds DataStore, catalog *Catalog) SimpleLogic {
 	// passing interfaces and returning a struct
 	return SimpleLogic{
-		l:  l,
-		ds: ds,
+		l:       l,
+		ds:      ds,
+		catalog: catalog,
 	}
 }
 
 // To our API, we only need a single endpoint, /hello, which says hello to the
 // person whose user ID is supplied. Our controller needs business logic:
 type Logic interface {
-	SayHello(userID string) (string, error)
+	SayHello(userID string, locales []string) (string, error)
 	// THis method is avaible on our SimpleLogic struct, but the concrete type
 	// is not aware of the interface
 }
@@ -132,10 +172,13 @@ type Controller struct {
 func (c Controller) SayHello(w http.ResponseWriter, r *http.Request) {
 	c.l.Log("In Sayhello")
 	userID := r.URL.Query().Get("user_id")
-	message, err := c.logic.SayHello(userID)
+	// The client tells us which languages it understands, in order of preference
+	locales := ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	message, err := c.logic.SayHello(userID, locales)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		// the kind of the error picks the status, CH8/errs maps it and writes
+		// a problem+json body with only the safe message
+		errs.WriteError(w, r, err)
 		return
 	}
 	w.Write([]byte(message))
@@ -149,11 +192,289 @@ func NewController(l Logger, logic Logic) Controller {
 	}
 }
 
+// Message catalog
+// The text shown to the users lives outside the code, one JSON file per locale
+// (en.json, es.json, fr.json...) inside a directory. Each file maps a message key
+// to its forms, a form is a text/template and it is picked by the gender of the
+// person and the plural category of the count:
+//
+//	"hello": {"female": "Bienvenida, {{.Name}}", "other": "Hola, {{.Name}}"}
+//
+// "other" is the form used when nothing more specific matches, so every message
+// must have it. A gender and plural form can be combined as "female.one"
+
+type MessageArgs struct {
+	Name   string
+	Gender string // "male", "female" or empty when we don't know it
+	Count  int
+}
+
+type Catalog struct {
+	fallback string
+	// locale -> message key -> form -> template
+	messages map[string]map[string]map[string]*template.Template
+}
+
+// Factory function, it loads every locale in dir and checks that all of them
+// define the same keys as the fallback locale, a missing translation is an error
+// at startup instead of an English message in the middle of a Spanish page
+func LoadCatalog(dir, fallback string) (*Catalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("in LoadCatalog: %w", err)
+	}
+	c := &Catalog{
+		fallback: strings.ToLower(fallback),
+		messages: map[string]map[string]map[string]*template.Template{},
+	}
+	for _, file := range files {
+		locale := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		messages, err := loadLocale(file)
+		if err != nil {
+			return nil, fmt.Errorf("in LoadCatalog: %w", err)
+		}
+		c.messages[locale] = messages
+	}
+	if err := c.checkKeys(); err != nil {
+		return nil, fmt.Errorf("in LoadCatalog: %w", err)
+	}
+	return c, nil
+}
+
+func loadLocale(file string) (map[string]map[string]*template.Template, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	messages := map[string]map[string]*template.Template{}
+	for key, forms := range raw {
+		if _, ok := forms["other"]; !ok {
+			return nil, fmt.Errorf("%s: message %q has no \"other\" form", file, key)
+		}
+		messages[key] = map[string]*template.Template{}
+		for form, text := range forms {
+			t, err := template.New(key + "/" + form).Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			messages[key][form] = t
+		}
+	}
+	return messages, nil
+}
+
+// Every locale must have exactly the keys of the fallback one, extra keys are
+// usually a typo in the name of a key that is then reported as missing
+func (c *Catalog) checkKeys() error {
+	base, ok := c.messages[c.fallback]
+	if !ok {
+		return fmt.Errorf("fallback locale %q not found", c.fallback)
+	}
+	var problems []string
+	for locale, messages := range c.messages {
+		for key := range base {
+			if _, ok := messages[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %q", locale, key))
+			}
+		}
+		for key := range messages {
+			if _, ok := base[key]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown %q", locale, key))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("incomplete catalog: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Message looks for key in the first locale of the chain that has it, every locale
+// is followed by its base language (es-mx -> es) and the chain ends with the
+// fallback locale. If the message can't be rendered, the key itself is returned,
+// something odd on the screen is better than an empty answer
+func (c *Catalog) Message(locales []string, key string, args MessageArgs) string {
+	for _, locale := range c.chain(locales) {
+		forms, ok := c.messages[locale][key]
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := selectForm(forms, args.Gender, pluralCategory(locale, args.Count)).Execute(&buf, args); err != nil {
+			return key
+		}
+		return buf.String()
+	}
+	return key
+}
+
+func (c *Catalog) chain(locales []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(locale string) {
+		if !seen[locale] {
+			seen[locale] = true
+			out = append(out, locale)
+		}
+	}
+	for _, locale := range locales {
+		locale = strings.ToLower(locale)
+		add(locale)
+		if i := strings.IndexByte(locale, '-'); i > 0 {
+			add(locale[:i])
+		}
+	}
+	add(c.fallback)
+	return out
+}
+
+// From the most specific form to the least one
+func selectForm(forms map[string]*template.Template, gender, plural string) *template.Template {
+	for _, form := range []string{gender + "." + plural, gender, plural} {
+		if t, ok := forms[form]; ok {
+			return t
+		}
+	}
+	return forms["other"]
+}
+
+// Plural rules are part of the language, not of the translation, so they stay
+// in the code. Languages without a rule only use "other"
+var pluralRules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"es": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"fr": func(n int) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+func pluralCategory(locale string, n int) string {
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		locale = locale[:i]
+	}
+	if rule, ok := pluralRules[locale]; ok {
+		return rule(n)
+	}
+	return "other"
+}
+
+// ParseAcceptLanguage turns "es-MX,es;q=0.9,en;q=0.8" into [es-mx es en], sorted
+// by the quality value. "*" and languages with q=0 are dropped, the catalog falls
+// back on its own
+func ParseAcceptLanguage(header string) []string {
+	type tag struct {
+		locale string
+		q      float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.ToLower(strings.TrimSpace(fields[0]))
+		if locale == "" || locale == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, tag{locale: locale, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	locales := make([]string, 0, len(tags))
+	for _, t := range tags {
+		locales = append(locales, t.locale)
+	}
+	return locales
+}
+
+// The locales live next to this file, not in whatever directory the program is
+// started from. runtime.Caller knows where the source was when it was built
+func sourceDir() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return "."
+	}
+	return filepath.Dir(file)
+}
+
+// checkCatalogs is the self-check for the catalogs: the real locales must have
+// every key, and an incomplete catalog (testdata/incomplete, de.json is missing
+// "goodbye" and has a misspelled "helo") must be rejected, or the first check
+// proves nothing
+func checkCatalogs(dir, incomplete string) bool {
+	ok := true
+	if _, err := LoadCatalog(dir, "en"); err != nil {
+		fmt.Println("FAIL", err)
+		ok = false
+	} else {
+		fmt.Println("ok", dir)
+	}
+	_, err := LoadCatalog(incomplete, "en")
+	for _, want := range []string{`de: missing "goodbye"`, `de: unknown "helo"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			fmt.Printf("FAIL %s: want an error with %s, got %v\n", incomplete, want, err)
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Println("ok", incomplete, "is rejected:", err)
+	}
+	return ok
+}
+
+// CH8/errs is imported with a relative path, there is no go.mod in this tree,
+// so this runs in GOPATH mode: GO111MODULE=off go run interfaces4.go [-check]
 func main() {
+	dir := flag.String("locales", filepath.Join(sourceDir(), "locales"), "directory of the locale files")
+	check := flag.Bool("check", false, "check that no catalog is missing any keys and exit")
+	flag.Parse()
+	if *check {
+		if !checkCatalogs(*dir, filepath.Join(sourceDir(), "testdata", "incomplete")) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Wiring up all of our components for the web app
 	l := LoggerAdapter(LogOutput)
+	// The catalog is loaded before anything else, if a locale file is broken or
+	// is missing a message we want to know now and not when a user hits it
+	catalog, err := LoadCatalog(*dir, "en")
+	if err != nil {
+		log.Fatal(err)
+	}
 	ds := NewSimpleDataStore()
-	logic := NewSimpleLogic(l, ds)
+	l.Log(catalog.Message(nil, "users_loaded", MessageArgs{Count: len(ds.userData)}))
+	logic := NewSimpleLogic(l, ds, catalog)
 	c := NewController(l, logic)
 	http.HandleFunc("/hello", c.SayHello)
 	http.ListenAndServe(":8080", nil)
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 )
 
 // Code methods for nil instances
@@ -39,6 +43,209 @@ func (it *IntTree) Contains(val int) bool { // doesn't modify the *IntTree
 	}
 }
 
+// IntTree encoding and drawing
+// fmt.Println(it) printed &{5 0xc000010030 0xc000010048}, the pointers of the
+// children. With String() it draws the tree, and the tree can be saved as
+// JSON, as a compact binary and as a Graphviz graph
+
+// intTreeJSON has the exported fields encoding/json needs, IntTree's are not
+type intTreeJSON struct {
+	Val   int      `json:"val"`
+	Left  *IntTree `json:"left,omitempty"`
+	Right *IntTree `json:"right,omitempty"`
+}
+
+// MarshalJSON writes {"val":5,"left":{"val":3},"right":{"val":10}}, a nil
+// tree is null (encoding/json does that one without calling us)
+func (it *IntTree) MarshalJSON() ([]byte, error) {
+	return json.Marshal(intTreeJSON{Val: it.val, Left: it.left, Right: it.right})
+}
+
+// UnmarshalJSON reads what MarshalJSON writes, and checks that it's still a
+// search tree, otherwise Contains would give wrong answers
+func (it *IntTree) UnmarshalJSON(data []byte) error {
+	var j intTreeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	// the children already checked themselves, only their extremes matter
+	if j.Left != nil && j.Left.max() >= j.Val {
+		return fmt.Errorf("in UnmarshalJSON: left of %d has %d", j.Val, j.Left.max())
+	}
+	if j.Right != nil && j.Right.min() <= j.Val {
+		return fmt.Errorf("in UnmarshalJSON: right of %d has %d", j.Val, j.Right.min())
+	}
+	*it = IntTree{val: j.Val, left: j.Left, right: j.Right}
+	return nil
+}
+
+func (it *IntTree) min() int {
+	for it.left != nil {
+		it = it.left
+	}
+	return it.val
+}
+
+func (it *IntTree) max() int {
+	for it.right != nil {
+		it = it.right
+	}
+	return it.val
+}
+
+// Binary format
+// The number of values and then the values in preorder (the node before its
+// children), all as varints, small numbers take one byte. The preorder of a
+// search tree is enough to build it back with the same shape, no need to
+// write where the nil children are
+
+var ErrInvalidTree = errors.New("invalid IntTree encoding")
+
+func (it *IntTree) MarshalBinary() ([]byte, error) {
+	var values []int
+	it.preorder(func(val int) { values = append(values, val) })
+	buf := binary.AppendUvarint(nil, uint64(len(values)))
+	for _, v := range values {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	return buf, nil
+}
+
+func (it *IntTree) preorder(visit func(int)) {
+	if it == nil {
+		return
+	}
+	visit(it.val)
+	it.left.preorder(visit)
+	it.right.preorder(visit)
+}
+
+// UnmarshalBinary needs a non nil receiver, for the empty tree use DecodeIntTree
+func (it *IntTree) UnmarshalBinary(data []byte) error {
+	t, err := DecodeIntTree(data)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("in UnmarshalBinary: %w: empty tree", ErrInvalidTree)
+	}
+	*it = *t
+	return nil
+}
+
+// DecodeIntTree reads what MarshalBinary writes, the empty tree is nil
+func DecodeIntTree(data []byte) (*IntTree, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("in DecodeIntTree: %w: bad count", ErrInvalidTree)
+	}
+	data = data[n:]
+	// every value takes at least a byte, don't trust a huge count
+	if count > uint64(len(data)) {
+		return nil, fmt.Errorf("in DecodeIntTree: %w: %d values in %d bytes", ErrInvalidTree, count, len(data))
+	}
+	values := make([]int, count)
+	for i := range values {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("in DecodeIntTree: %w: bad value %d", ErrInvalidTree, i)
+		}
+		values[i], data = int(v), data[n:]
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("in DecodeIntTree: %w: %d bytes left over", ErrInvalidTree, len(data))
+	}
+
+	// build it back, each call takes the values that fit between lo and hi
+	pos := 0
+	var build func(lo, hi *int) *IntTree
+	build = func(lo, hi *int) *IntTree {
+		if pos == len(values) {
+			return nil
+		}
+		v := values[pos]
+		if lo != nil && v <= *lo || hi != nil && v >= *hi {
+			return nil
+		}
+		pos++
+		return &IntTree{val: v, left: build(lo, &v), right: build(&v, hi)}
+	}
+	t := build(nil, nil)
+	if pos != len(values) {
+		// a value that fit nowhere, it wasn't the preorder of a search tree
+		return nil, fmt.Errorf("in DecodeIntTree: %w: value %d is out of order", ErrInvalidTree, values[pos])
+	}
+	return t, nil
+}
+
+// String draws the tree, the left child first
+//
+//	5
+//	├── 3
+//	│   ├── 2
+//	│   └── ·
+//	└── 10
+//
+// when a node has only one child the missing one shows as ·, so the two sides
+// can't be confused
+func (it *IntTree) String() string {
+	if it == nil {
+		return "<empty>"
+	}
+	var b strings.Builder
+	fmt.Fprintln(&b, it.val)
+	it.drawChildren(&b, "")
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (it *IntTree) drawChildren(b *strings.Builder, prefix string) {
+	switch {
+	case it.left == nil && it.right == nil:
+		return
+	case it.left == nil:
+		fmt.Fprintf(b, "%s├── ·\n", prefix)
+	default:
+		fmt.Fprintf(b, "%s├── %d\n", prefix, it.left.val)
+		it.left.drawChildren(b, prefix+"│   ")
+	}
+	if it.right == nil {
+		fmt.Fprintf(b, "%s└── ·\n", prefix)
+	} else {
+		fmt.Fprintf(b, "%s└── %d\n", prefix, it.right.val)
+		it.right.drawChildren(b, prefix+"    ")
+	}
+}
+
+// DOT is the tree for Graphviz, save it in tree.dot and dot -Tpng tree.dot -o tree.png.
+// A missing child is an invisible point so a lone right child is still drawn
+// to the right. The IDs are quoted, n-5 isn't a valid one without the quotes
+func (it *IntTree) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph IntTree {\n\tnode [shape=circle];\n")
+	nils := 0
+	var walk func(t *IntTree)
+	walk = func(t *IntTree) {
+		fmt.Fprintf(&b, "\t\"n%d\" [label=\"%d\"];\n", t.val, t.val)
+		if t.left == nil && t.right == nil {
+			return
+		}
+		for _, child := range []*IntTree{t.left, t.right} {
+			if child == nil {
+				nils++
+				fmt.Fprintf(&b, "\tnil%d [shape=point, style=invis];\n\t\"n%d\" -> nil%d [style=invis];\n", nils, t.val, nils)
+				continue
+			}
+			fmt.Fprintf(&b, "\t\"n%d\" -> \"n%d\";\n", t.val, child.val)
+			walk(child)
+		}
+	}
+	if it != nil {
+		walk(it)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
 // You can't write a pointer receiver method that handles nil and makes the
 // original non-nil because it's a copy of the pointer that's passed into the method.
 
@@ -64,7 +271,20 @@ func main() {
 	it = it.Insert(2)
 	fmt.Println(it.Contains(2))
 	fmt.Println(it.Contains(12))
-	fmt.Println(it)
+	fmt.Println(it) // with String() it draws the tree
+
+	// Round trips, the tree read back draws the same
+	data, err := json.Marshal(it)
+	fmt.Println(string(data), err)
+	var fromJSON *IntTree
+	err = json.Unmarshal(data, &fromJSON)
+	fmt.Println(fromJSON.String() == it.String(), err)
+	bin, _ := it.MarshalBinary()
+	fromBin, err := DecodeIntTree(bin)
+	fmt.Printf("%d bytes %v, %v %v\n", len(bin), bin, fromBin.String() == it.String(), err)
+	_, err = DecodeIntTree([]byte{3, 10, 20, 6}) // 5 10 3 isn't a preorder, 3 after 10
+	fmt.Println(err)
+	fmt.Print(it.DOT())
 
 	// Invoking methods
 	myAdder := Adder{start: 10}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export
+// Snapshot copies every metric at one moment, then it's written as JSON or in
+// the Prometheus text format. Each metric is locked only while it's copied, so
+// the snapshot doesn't stop the program, but two metrics can be a few
+// nanoseconds apart
+
+type Snapshot struct {
+	Time       time.Time           `json:"time"`
+	Counters   []CounterSnapshot   `json:"counters"`
+	Gauges     []GaugeSnapshot     `json:"gauges"`
+	Histograms []HistogramSnapshot `json:"histograms"`
+}
+
+type CounterSnapshot struct {
+	Name        string    `json:"name"`
+	Help        string    `json:"help,omitempty"`
+	Total       uint64    `json:"total"`
+	LastUpdated time.Time `json:"last_updated"`
+	Rates       Rates     `json:"rates"`
+}
+
+type GaugeSnapshot struct {
+	Name        string    `json:"name"`
+	Help        string    `json:"help,omitempty"`
+	Value       Float     `json:"value"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// Float is a float64 that JSON can always encode: encoding/json fails on
+// +Inf, -Inf and NaN, and a gauge or a sum can be any of them, so those go
+// as the strings Prometheus uses
+type Float float64
+
+func (f Float) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return json.Marshal(formatFloat(v))
+	}
+	return json.Marshal(v)
+}
+
+type Bucket struct {
+	UpperBound Float  `json:"le"` // +Inf for the last one
+	Count      uint64 `json:"count"`
+}
+
+type HistogramSnapshot struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help,omitempty"`
+	Buckets []Bucket `json:"buckets"` // cumulative, like Prometheus
+	Count   uint64   `json:"count"`
+	Sum     Float    `json:"sum"`
+	Rates   Rates    `json:"rates"`
+}
+
+// sortedValues returns the values of m ordered by key, so the exports don't
+// change order between calls
+func sortedValues[V any](m map[string]V) []V {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	values := make([]V, len(keys))
+	for i, k := range keys {
+		values[i] = m[k]
+	}
+	return values
+}
+
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	counters, gauges, histograms := sortedValues(r.counters), sortedValues(r.gauges), sortedValues(r.histograms)
+	r.mu.Unlock()
+
+	now := r.now()
+	s := Snapshot{
+		Time:       now,
+		Counters:   []CounterSnapshot{},
+		Gauges:     []GaugeSnapshot{},
+		Histograms: []HistogramSnapshot{},
+	}
+	for _, c := range counters {
+		c.mu.Lock()
+		s.Counters = append(s.Counters, CounterSnapshot{
+			Name: c.name, Help: c.help, Total: c.total, LastUpdated: c.lastUpdated, Rates: c.rates.rates(now),
+		})
+		c.mu.Unlock()
+	}
+	for _, g := range gauges {
+		g.mu.Lock()
+		s.Gauges = append(s.Gauges, GaugeSnapshot{Name: g.name, Help: g.help, Value: Float(g.value), LastUpdated: g.lastUpdated})
+		g.mu.Unlock()
+	}
+	for _, h := range histograms {
+		h.mu.Lock()
+		hs := HistogramSnapshot{Name: h.name, Help: h.help, Count: h.count, Sum: Float(h.sum), Rates: h.rates.rates(now)}
+		var cumulative uint64
+		for i, n := range h.counts {
+			cumulative += n
+			le := math.Inf(1)
+			if i < len(h.bounds) {
+				le = h.bounds[i]
+			}
+			hs.Buckets = append(hs.Buckets, Bucket{UpperBound: Float(le), Count: cumulative})
+		}
+		h.mu.Unlock()
+		s.Histograms = append(s.Histograms, hs)
+	}
+	return s
+}
+
+func (s Snapshot) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WritePrometheus writes the text exposition format. Prometheus computes its
+// own rates from the totals, the 1m/5m/15m ones go as an extra gauge,
+// name_rate{window="1m"}, for whoever wants them without PromQL
+func (s Snapshot) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+	header := func(name, help, kind string) {
+		if help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(help))
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, kind)
+	}
+	rates := func(name string, r Rates) {
+		fmt.Fprintf(&b, "# TYPE %s_rate gauge\n", name)
+		fmt.Fprintf(&b, "%s_rate{window=\"1m\"} %s\n", name, formatFloat(r.M1))
+		fmt.Fprintf(&b, "%s_rate{window=\"5m\"} %s\n", name, formatFloat(r.M5))
+		fmt.Fprintf(&b, "%s_rate{window=\"15m\"} %s\n", name, formatFloat(r.M15))
+	}
+	for _, c := range s.Counters {
+		header(c.Name, c.Help, "counter")
+		fmt.Fprintf(&b, "%s %d\n", c.Name, c.Total)
+		rates(c.Name, c.Rates)
+	}
+	for _, g := range s.Gauges {
+		header(g.Name, g.Help, "gauge")
+		fmt.Fprintf(&b, "%s %s\n", g.Name, formatFloat(float64(g.Value)))
+	}
+	for _, h := range s.Histograms {
+		header(h.Name, h.Help, "histogram")
+		for _, bucket := range h.Buckets {
+			fmt.Fprintf(&b, "%s_bucket{le=\"%s\"} %d\n", h.Name, formatFloat(float64(bucket.UpperBound)), bucket.Count)
+		}
+		fmt.Fprintf(&b, "%s_sum %s\n", h.Name, formatFloat(float64(h.Sum)))
+		fmt.Fprintf(&b, "%s_count %d\n", h.Name, h.Count)
+		rates(h.Name, h.Rates)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes what the format asks for in HELP lines
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// Handler serves the metrics, in the Prometheus format by default and as JSON
+// with ?format=json
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s := r.Snapshot()
+		// into a buffer first, so a failure is a 500 and not a 200 with half a body
+		var buf bytes.Buffer
+		var err error
+		contentType := "text/plain; version=0.0.4; charset=utf-8"
+		if req.URL.Query().Get("format") == "json" {
+			contentType = "application/json"
+			err = s.WriteJSON(&buf)
+		} else {
+			err = s.WritePrometheus(&buf)
+		}
+		if err != nil {
+			log.Println("in Handler:", err)
+			http.Error(w, "metrics export failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+)
+
+// fakeClock only moves when the demo says so, the rates come out the same
+// every run
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func main() {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	r := NewRegistry(clock.Now)
+	requests := r.Counter("http_requests_total", "Requests served.")
+	inFlight := r.Gauge("http_requests_in_flight", "Requests being served now.")
+	latency := r.Histogram("http_request_duration_seconds", "How long the requests took.", []float64{0.05, 0.1, 0.5, 1})
+
+	// serve pretends to take d of the fake time
+	serve := func(d time.Duration) {
+		start := clock.Now()
+		inFlight.Add(1)
+		requests.Inc()
+		clock.Advance(d)
+		latency.Since(start)
+		inFlight.Add(-1)
+	}
+
+	// 10 minutes at 1 request per second, then a minute at 10 per second: the
+	// 1m rate follows the burst right away, the 15m one barely moves
+	for i := 0; i < 600; i++ {
+		serve(20 * time.Millisecond)
+		clock.Advance(time.Second - 20*time.Millisecond)
+	}
+	fmt.Printf("after 10m at 1/s: %+v\n", requests.Rates())
+	for i := 0; i < 600; i++ {
+		serve(80 * time.Millisecond)
+		clock.Advance(100*time.Millisecond - 80*time.Millisecond)
+	}
+	fmt.Printf("after 1m at 10/s: %+v\n", requests.Rates())
+	clock.Advance(5 * time.Minute)
+	fmt.Printf("5m of silence:    %+v\n", requests.Rates())
+	fmt.Println(requests)
+	fmt.Println()
+
+	if err := r.Snapshot().WritePrometheus(os.Stdout); err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println()
+
+	// the same through the handler, as JSON
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics?format=json", nil))
+	body, _ := io.ReadAll(rec.Body)
+	fmt.Println(rec.Code, rec.Header().Get("Content-Type"))
+	fmt.Println(string(body))
+
+	// The Counter of methods1.go would lose increments here, this one doesn't
+	// (go run -race . doesn't complain either)
+	real := NewRegistry(nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := real.Counter("events_total", "") // the same counter for everybody
+			h := real.Histogram("sizes", "", []float64{10, 100})
+			for i := 0; i < 10000; i++ {
+				c.Inc()
+				h.Observe(float64(i % 200))
+			}
+		}()
+	}
+	wg.Wait()
+	s := real.Snapshot()
+	fmt.Println("8 goroutines x 10000:", s.Counters[0].Total, "events,", s.Histograms[0].Count, "observations")
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Metrics
+// The Counter of methods1.go with a lock, so many goroutines can use it, and
+// with the rates of the last 1, 5 and 15 minutes. Gauges are values that go
+// up and down, histograms count observations in buckets. A Registry has them
+// by name and exports all of them at once, see export.go
+//
+// Nothing calls time.Now directly, the time comes from the Registry's clock,
+// so a fake clock makes the rates deterministic
+
+type Registry struct {
+	now        func() time.Time
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+}
+
+// NewRegistry uses now as its clock, nil is time.Now
+func NewRegistry(now func() time.Time) *Registry {
+	if now == nil {
+		now = time.Now
+	}
+	return &Registry{
+		now:        now,
+		counters:   map[string]*Counter{},
+		gauges:     map[string]*Gauge{},
+		histograms: map[string]*Histogram{},
+	}
+}
+
+// the names Prometheus accepts
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// checkName panics, like regexp.MustCompile, a bad name or a name used by two
+// kinds of metrics is a bug in the program, not something to handle at runtime
+func (r *Registry) checkName(name, kind string) {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid name %q", name))
+	}
+	_, c := r.counters[name]
+	_, g := r.gauges[name]
+	_, h := r.histograms[name]
+	if (c && kind != "counter") || (g && kind != "gauge") || (h && kind != "histogram") {
+		panic(fmt.Sprintf("metrics: %q is already registered as another kind of metric", name))
+	}
+	if c || g || h {
+		return // the same metric again, its names are its own
+	}
+	// the export adds names too, a gauge hits_rate next to a counter hits
+	// would be two hits_rate families, and Prometheus rejects the page
+	taken := map[string]string{}
+	for other, kind := range r.kinds() {
+		for _, n := range exportedNames(other, kind) {
+			taken[n] = other
+		}
+	}
+	for _, n := range exportedNames(name, kind) {
+		if other, ok := taken[n]; ok {
+			panic(fmt.Sprintf("metrics: %q would export %s, and so does %q", name, n, other))
+		}
+	}
+}
+
+func (r *Registry) kinds() map[string]string {
+	kinds := map[string]string{}
+	for name := range r.counters {
+		kinds[name] = "counter"
+	}
+	for name := range r.gauges {
+		kinds[name] = "gauge"
+	}
+	for name := range r.histograms {
+		kinds[name] = "histogram"
+	}
+	return kinds
+}
+
+// exportedNames are the names WritePrometheus writes for a metric
+func exportedNames(name, kind string) []string {
+	switch kind {
+	case "counter":
+		return []string{name, name + "_rate"}
+	case "histogram":
+		return []string{name, name + "_bucket", name + "_sum", name + "_count", name + "_rate"}
+	default:
+		return []string{name}
+	}
+}
+
+// Counter returns the counter called name, it's created the first time
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkName(name, "counter")
+	if c, ok := r.counters[name]; ok {
+		return c
+	}
+	c := &Counter{name: name, help: help, now: r.now, rates: newWindow(r.now())}
+	r.counters[name] = c
+	return c
+}
+
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkName(name, "gauge")
+	if g, ok := r.gauges[name]; ok {
+		return g
+	}
+	g := &Gauge{name: name, help: help, now: r.now}
+	r.gauges[name] = g
+	return g
+}
+
+// Histogram returns the histogram called name, buckets are the upper bounds,
+// an observation goes in the first bucket >= it. A histogram that already
+// exists keeps its buckets
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkName(name, "histogram")
+	if h, ok := r.histograms[name]; ok {
+		return h
+	}
+	bounds := slices.Clone(buckets)
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+	h := &Histogram{
+		name:   name,
+		help:   help,
+		now:    r.now,
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1), // the last one is +Inf
+		rates:  newWindow(r.now()),
+	}
+	r.histograms[name] = h
+	return h
+}
+
+// Counter only goes up
+type Counter struct {
+	name, help  string
+	now         func() time.Time
+	mu          sync.Mutex
+	total       uint64
+	lastUpdated time.Time
+	rates       *window
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += n
+	c.lastUpdated = now
+	c.rates.add(now, float64(n))
+}
+
+func (c *Counter) Total() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// Rates are per second, like the load average they smooth over the window
+func (c *Counter) Rates() Rates {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rates.rates(now)
+}
+
+func (c *Counter) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("total: %d, last updated: %v", c.total, c.lastUpdated)
+}
+
+// Gauge is a value that goes up and down, like the requests in flight
+type Gauge struct {
+	name, help  string
+	now         func() time.Time
+	mu          sync.Mutex
+	value       float64
+	lastUpdated time.Time
+}
+
+func (g *Gauge) Set(v float64) {
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = v
+	g.lastUpdated = now
+}
+
+func (g *Gauge) Add(delta float64) {
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += delta
+	g.lastUpdated = now
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// Histogram counts observations, a latency, a size, in buckets
+type Histogram struct {
+	name, help string
+	now        func() time.Time
+	mu         sync.Mutex
+	bounds     []float64
+	counts     []uint64 // not cumulative, the export adds them up
+	count      uint64
+	sum        float64
+	rates      *window // of observations
+}
+
+func (h *Histogram) Observe(v float64) {
+	now := h.now()
+	i, _ := slices.BinarySearch(h.bounds, v) // the first bound >= v
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.rates.add(now, 1)
+}
+
+// Since observes the time that passed since start, in seconds
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(h.now().Sub(start).Seconds())
+}
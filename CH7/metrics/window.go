@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+)
+
+// window keeps how much happened in each 5 second slot of the last 15 minutes,
+// a ring of 180 slots, 5 seconds like the tick of the load average. The rate
+// of the last minute is the sum of the last 12 slots over the time they cover.
+// It isn't safe for concurrent use, the metric that has it locks
+
+const (
+	slotWidth = 5 * time.Second
+	slots     = int(15 * time.Minute / slotWidth)
+)
+
+type window struct {
+	start    time.Time // a new metric's rate isn't diluted by the time before it existed
+	slotTime time.Time // start of the slot at head, the current one
+	head     int
+	counts   [slots]float64
+}
+
+func newWindow(now time.Time) *window {
+	return &window{start: now, slotTime: now.Truncate(slotWidth)}
+}
+
+// advance moves head to the slot of now, zeroing the slots it skips
+func (w *window) advance(now time.Time) {
+	steps := int(now.Truncate(slotWidth).Sub(w.slotTime) / slotWidth)
+	if steps <= 0 {
+		// same slot, or the clock went back, it counts in the current one
+		return
+	}
+	if steps >= slots {
+		w.counts = [slots]float64{}
+	} else {
+		for i := 0; i < steps; i++ {
+			w.head = (w.head + 1) % slots
+			w.counts[w.head] = 0
+		}
+	}
+	w.slotTime = now.Truncate(slotWidth)
+}
+
+func (w *window) add(now time.Time, v float64) {
+	w.advance(now)
+	w.counts[w.head] += v
+}
+
+// rate is per second over the last d, d a multiple of 5s up to 15m
+func (w *window) rate(now time.Time, d time.Duration) float64 {
+	w.advance(now)
+	n := int(d / slotWidth)
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		sum += w.counts[(w.head-i+slots)%slots]
+	}
+	// n-1 full slots and the part of the current one that already passed
+	span := time.Duration(n-1)*slotWidth + now.Sub(w.slotTime)
+	// a young metric is divided by its age, not by the whole window, but at
+	// least by a slot, or one event in its first millisecond would be 1000/s
+	span = min(span, max(now.Sub(w.start), slotWidth))
+	return sum / span.Seconds()
+}
+
+// Rates are the per second rates of the last 1, 5 and 15 minutes
+type Rates struct {
+	M1  float64 `json:"1m"`
+	M5  float64 `json:"5m"`
+	M15 float64 `json:"15m"`
+}
+
+func (w *window) rates(now time.Time) Rates {
+	return Rates{
+		M1:  w.rate(now, time.Minute),
+		M5:  w.rate(now, 5*time.Minute),
+		M15: w.rate(now, 15*time.Minute),
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Benchmark
+// Readers in parallel against one writer that never stops. With the mutex the
+// readers and the writer wait for each other, with Shared a reader loads the
+// current version and never waits, the writer only waits for other writers
+
+type sharedTree interface {
+	Get(key int) (int, bool)
+	Scan(lo, hi int) int // reads a range, like a report would
+	Put(key, val int)
+}
+
+type lockedTree struct {
+	mu sync.RWMutex
+	t  *Tree[int, int]
+}
+
+func (l *lockedTree) Get(key int) (int, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.t.Get(key)
+}
+
+func (l *lockedTree) Scan(lo, hi int) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	sum := 0
+	for _, v := range l.t.Range(lo, hi) {
+		sum += v
+	}
+	return sum
+}
+
+func (l *lockedTree) Put(key, val int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.t.Put(key, val)
+}
+
+type persistentTree struct {
+	s *Shared[int, int]
+}
+
+func (p persistentTree) Get(key int) (int, bool) {
+	return p.s.Snapshot().Get(key)
+}
+
+func (p persistentTree) Scan(lo, hi int) int {
+	sum := 0
+	for _, v := range p.s.Snapshot().Range(lo, hi) {
+		sum += v
+	}
+	return sum
+}
+
+func (p persistentTree) Put(key, val int) {
+	p.s.Update(func(t Persistent[int, int]) Persistent[int, int] {
+		return t.Put(key, val)
+	})
+}
+
+const benchKeys = 10000
+
+func benchmark(t sharedTree, scan bool) (testing.BenchmarkResult, int64) {
+	for i := 0; i < benchKeys; i++ {
+		t.Put(i, i)
+	}
+	var writes atomic.Int64
+	result := testing.Benchmark(func(b *testing.B) {
+		writes.Store(0) // Benchmark calls this a few times, the last one counts
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				t.Put(i*7919%benchKeys, i)
+				writes.Add(1)
+			}
+		}()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				key := i * 104729 % benchKeys
+				if scan {
+					t.Scan(key, key+100)
+				} else {
+					t.Get(key)
+				}
+				i++
+			}
+		})
+		close(stop)
+		wg.Wait()
+	})
+	return result, writes.Load()
+}
+
+func benchmarks() {
+	for _, scan := range []bool{false, true} {
+		kind := "Get"
+		if scan {
+			kind = "Range of 100"
+		}
+		for _, c := range []struct {
+			name string
+			t    sharedTree
+		}{
+			{"RWMutex + Tree", &lockedTree{t: NewOrdered[int, int]()}},
+			{"Shared Persistent", persistentTree{NewShared[int, int](cmp.Compare[int])}},
+		} {
+			r, writes := benchmark(c.t, scan)
+			fmt.Printf("%-12s %-18s %8d ns/read  %9d writes meanwhile\n", kind, c.name, r.NsPerOp(), writes)
+		}
+	}
+}
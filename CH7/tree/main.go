@@ -0,0 +1,243 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The repo doesn't have test files, so main checks the tree: random Puts and
+// Deletes, and after each one every query is compared with the same query done
+// on a sorted slice, the slow but obviously right version
+
+func main() {
+	// a map, keys in the natural order
+	ages := NewOrdered[string, int]()
+	ages.Put("bob", 30)
+	ages.Put("alice", 25)
+	ages.Put("carol", 35)
+	ages.Put("dave", 40)
+	for name, age := range ages.All() {
+		fmt.Println(name, age)
+	}
+	k, _, _ := ages.Floor("bz")
+	fmt.Println("floor of bz:", k, "rank of carol:", ages.Rank("carol"))
+
+	// a set with a comparator, case insensitive
+	words := New[string, struct{}](func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	for _, w := range strings.Fields("the Quick brown fox jumps over The lazy dog") {
+		words.Put(w, struct{}{})
+	}
+	var in []string
+	for w := range words.Range("d", "p") {
+		in = append(in, w)
+	}
+	fmt.Println(words.Len(), "words, from d to p:", in)
+
+	// 1..1000 in order would make IntTree 1000 levels deep
+	sorted := NewOrdered[int, int]()
+	for i := 1; i <= 1000; i++ {
+		sorted.Put(i, i)
+	}
+	fmt.Println("height after 1000 sorted inserts:", sorted.root.getHeight())
+
+	if err := property(rand.New(rand.NewSource(1)), 20000, 500); err != nil {
+		fmt.Println("FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Println("20000 random operations match the sorted slice")
+
+	if err := persistentProperty(rand.New(rand.NewSource(2)), 5000, 300); err != nil {
+		fmt.Println("FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Println("5000 persistent versions match the Tree, and none of them changed")
+
+	benchmarks()
+}
+
+// persistentProperty does the same operations on a Tree and a Persistent and
+// keeps every version of the Persistent with the keys it had, at the end all
+// the old versions must still have exactly those keys
+func persistentProperty(r *rand.Rand, ops, keySpace int) error {
+	t := NewOrdered[int, int]()
+	p := NewPersistent[int, int](cmp.Compare[int])
+	type version struct {
+		p    Persistent[int, int]
+		keys []int
+	}
+	var versions []version
+	for op := 0; op < ops; op++ {
+		key := r.Intn(keySpace)
+		if r.Intn(3) == 0 {
+			t.Delete(key)
+			p = p.Delete(key)
+		} else {
+			t.Put(key, op)
+			p = p.Put(key, op)
+		}
+		if err := checkNode(t, p.root); err != nil {
+			return fmt.Errorf("op %d: %w", op, err)
+		}
+		var keys []int
+		for k, v := range t.All() {
+			if pv, ok := p.Get(k); !ok || pv != v {
+				return fmt.Errorf("op %d: Get(%d) = %d, %v, want %d", op, k, pv, ok, v)
+			}
+			keys = append(keys, k)
+		}
+		if p.Len() != len(keys) {
+			return fmt.Errorf("op %d: Len() = %d, want %d", op, p.Len(), len(keys))
+		}
+		versions = append(versions, version{p, keys})
+	}
+	for i, v := range versions {
+		var got []int
+		for k := range v.p.All() {
+			got = append(got, k)
+		}
+		if !slices.Equal(got, v.keys) {
+			return fmt.Errorf("version %d changed: %v, want %v", i, got, v.keys)
+		}
+	}
+	return nil
+}
+
+// model is the sorted slice the tree is compared with
+type model struct {
+	keys []int
+	vals map[int]int
+}
+
+func property(r *rand.Rand, ops, keySpace int) error {
+	t := NewOrdered[int, int]()
+	m := model{vals: map[int]int{}}
+	for op := 0; op < ops; op++ {
+		key := r.Intn(keySpace)
+		if r.Intn(3) == 0 {
+			i, found := slices.BinarySearch(m.keys, key)
+			if found {
+				m.keys = slices.Delete(m.keys, i, i+1)
+				delete(m.vals, key)
+			}
+			if got := t.Delete(key); got != found {
+				return fmt.Errorf("op %d: Delete(%d) = %v, want %v", op, key, got, found)
+			}
+		} else {
+			i, found := slices.BinarySearch(m.keys, key)
+			if !found {
+				m.keys = slices.Insert(m.keys, i, key)
+			}
+			m.vals[key] = op
+			if got := t.Put(key, op); got != !found {
+				return fmt.Errorf("op %d: Put(%d) = %v, want %v", op, key, got, !found)
+			}
+		}
+		if err := checkNode(t, t.root); err != nil {
+			return fmt.Errorf("op %d: %w", op, err)
+		}
+		if err := compare(t, m, r.Intn(keySpace+2)-1, r.Intn(keySpace+2)-1); err != nil {
+			return fmt.Errorf("op %d: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// checkNode checks the order, the AVL balance and the heights and sizes
+func checkNode[K, V any](t *Tree[K, V], n *node[K, V]) error {
+	if n == nil {
+		return nil
+	}
+	if n.left != nil && t.cmp(n.left.key, n.key) >= 0 || n.right != nil && t.cmp(n.right.key, n.key) <= 0 {
+		return fmt.Errorf("node %v is out of order", n.key)
+	}
+	if bf := n.balanceFactor(); bf < -1 || bf > 1 {
+		return fmt.Errorf("node %v has balance factor %d", n.key, bf)
+	}
+	// recomputed without update(), the nodes of a Persistent are shared and
+	// must not be written, not even by the check
+	height := 1 + max(n.left.getHeight(), n.right.getHeight())
+	size := 1 + n.left.getSize() + n.right.getSize()
+	if n.height != height || n.size != size {
+		return fmt.Errorf("node %v has height %d size %d, want %d %d", n.key, n.height, n.size, height, size)
+	}
+	if err := checkNode(t, n.left); err != nil {
+		return err
+	}
+	return checkNode(t, n.right)
+}
+
+func compare(t *Tree[int, int], m model, key, hi int) error {
+	if t.Len() != len(m.keys) {
+		return fmt.Errorf("Len() = %d, want %d", t.Len(), len(m.keys))
+	}
+	i, found := slices.BinarySearch(m.keys, key)
+	if v, ok := t.Get(key); ok != found || v != m.vals[key] {
+		return fmt.Errorf("Get(%d) = %d, %v, want %d, %v", key, v, ok, m.vals[key], found)
+	}
+	if got := t.Rank(key); got != i {
+		return fmt.Errorf("Rank(%d) = %d, want %d", key, got, i)
+	}
+
+	// want is what the slice says about a key at index j, if j is in range
+	want := func(j int) (int, bool) {
+		if j < 0 || j >= len(m.keys) {
+			return 0, false
+		}
+		return m.keys[j], true
+	}
+	check := func(name string, gotK, gotV int, gotOK bool, j int) error {
+		k, ok := want(j)
+		if gotOK != ok || ok && (gotK != k || gotV != m.vals[k]) {
+			return fmt.Errorf("%s = %d, %d, %v, want %d, %v", name, gotK, gotV, gotOK, k, ok)
+		}
+		return nil
+	}
+	floor := i - 1
+	if found {
+		floor = i
+	}
+	// the positions the slice gives for each query, in the same order
+	for _, q := range []struct {
+		name  string
+		query func() (int, int, bool)
+		j     int
+	}{
+		{"Min()", t.Min, 0},
+		{"Max()", t.Max, len(m.keys) - 1},
+		{fmt.Sprintf("Floor(%d)", key), func() (int, int, bool) { return t.Floor(key) }, floor},
+		{fmt.Sprintf("Ceiling(%d)", key), func() (int, int, bool) { return t.Ceiling(key) }, i},
+		{fmt.Sprintf("Select(%d)", i), func() (int, int, bool) { return t.Select(i) }, i},
+	} {
+		k, v, ok := q.query()
+		if err := check(q.name, k, v, ok, q.j); err != nil {
+			return err
+		}
+	}
+
+	var got []int
+	for k := range t.Range(key, hi) {
+		got = append(got, k)
+	}
+	lo, _ := slices.BinarySearch(m.keys, key)
+	end, _ := slices.BinarySearch(m.keys, hi)
+	if end < lo {
+		end = lo
+	}
+	if !slices.Equal(got, m.keys[lo:end]) {
+		return fmt.Errorf("Range(%d, %d) = %v, want %v", key, hi, got, m.keys[lo:end])
+	}
+	// stopping early must work too, calling yield again after a break panics
+	n := 0
+	for range t.All() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	return nil
+}
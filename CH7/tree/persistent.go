@@ -0,0 +1,154 @@
+package main
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// Persistent tree
+// Tree, and IntTree, change their nodes in place, so a goroutine reading while
+// another inserts needs a lock like in concurrency8.go. A Persistent never
+// changes a node after it's built: Put and Delete copy only the nodes on the
+// path from the root to the key, O(log n) of them, and return a new tree that
+// shares every other node with the old one. The old tree is still there and
+// still valid, so keeping a version is just keeping the value, a snapshot
+// costs nothing
+//
+// It uses the same nodes as Tree and the same AVL balance, but the rotations
+// build new nodes instead of moving pointers
+
+type Persistent[K, V any] struct {
+	root *node[K, V]
+	cmp  func(a, b K) int
+}
+
+// NewPersistent returns an empty tree ordered by cmp, the zero value needs a cmp
+func NewPersistent[K, V any](cmp func(a, b K) int) Persistent[K, V] {
+	return Persistent[K, V]{cmp: cmp}
+}
+
+// mk builds a new node, the children are shared
+func mk[K, V any](key K, val V, left, right *node[K, V]) *node[K, V] {
+	n := &node[K, V]{key: key, val: val, left: left, right: right}
+	n.update()
+	return n
+}
+
+// balanced is mk that also rotates when left and right differ in height by
+// two, which is the most an insert or a delete below can do
+func balanced[K, V any](key K, val V, l, r *node[K, V]) *node[K, V] {
+	switch {
+	case l.getHeight()-r.getHeight() > 1:
+		if l.left.getHeight() >= l.right.getHeight() { // single rotation
+			return mk(l.key, l.val, l.left, mk(key, val, l.right, r))
+		}
+		lr := l.right // double rotation, lr ends up on top
+		return mk(lr.key, lr.val, mk(l.key, l.val, l.left, lr.left), mk(key, val, lr.right, r))
+	case r.getHeight()-l.getHeight() > 1:
+		if r.right.getHeight() >= r.left.getHeight() {
+			return mk(r.key, r.val, mk(key, val, l, r.left), r.right)
+		}
+		rl := r.left
+		return mk(rl.key, rl.val, mk(key, val, l, rl.left), mk(r.key, r.val, rl.right, r.right))
+	}
+	return mk(key, val, l, r)
+}
+
+func (p Persistent[K, V]) insert(n *node[K, V], key K, val V) *node[K, V] {
+	if n == nil {
+		return mk[K, V](key, val, nil, nil)
+	}
+	switch c := p.cmp(key, n.key); {
+	case c < 0:
+		return balanced(n.key, n.val, p.insert(n.left, key, val), n.right)
+	case c > 0:
+		return balanced(n.key, n.val, n.left, p.insert(n.right, key, val))
+	default:
+		return mk(key, val, n.left, n.right)
+	}
+}
+
+// Put returns a tree where key has val, p doesn't change
+func (p Persistent[K, V]) Put(key K, val V) Persistent[K, V] {
+	return Persistent[K, V]{root: p.insert(p.root, key, val), cmp: p.cmp}
+}
+
+// withoutMin returns the subtree without its smallest node, and that node
+func withoutMin[K, V any](n *node[K, V]) (*node[K, V], *node[K, V]) {
+	if n.left == nil {
+		return n.right, n
+	}
+	left, smallest := withoutMin(n.left)
+	return balanced(n.key, n.val, left, n.right), smallest
+}
+
+func (p Persistent[K, V]) delete(n *node[K, V], key K) *node[K, V] {
+	switch c := p.cmp(key, n.key); {
+	case c < 0:
+		return balanced(n.key, n.val, p.delete(n.left, key), n.right)
+	case c > 0:
+		return balanced(n.key, n.val, n.left, p.delete(n.right, key))
+	}
+	if n.left == nil {
+		return n.right
+	}
+	if n.right == nil {
+		return n.left
+	}
+	right, succ := withoutMin(n.right)
+	return balanced(succ.key, succ.val, n.left, right)
+}
+
+// Delete returns a tree without key, if key isn't there it's p itself
+func (p Persistent[K, V]) Delete(key K) Persistent[K, V] {
+	if !p.Contains(key) {
+		return p
+	}
+	return Persistent[K, V]{root: p.delete(p.root, key), cmp: p.cmp}
+}
+
+// The queries don't change anything, so they are the ones of Tree
+func (p Persistent[K, V]) view() *Tree[K, V] {
+	return &Tree[K, V]{root: p.root, cmp: p.cmp}
+}
+
+func (p Persistent[K, V]) Get(key K) (V, bool)            { return p.view().Get(key) }
+func (p Persistent[K, V]) Contains(key K) bool            { return p.view().Contains(key) }
+func (p Persistent[K, V]) Len() int                       { return p.root.getSize() }
+func (p Persistent[K, V]) Min() (K, V, bool)              { return p.view().Min() }
+func (p Persistent[K, V]) Max() (K, V, bool)              { return p.view().Max() }
+func (p Persistent[K, V]) Floor(key K) (K, V, bool)       { return p.view().Floor(key) }
+func (p Persistent[K, V]) Ceiling(key K) (K, V, bool)     { return p.view().Ceiling(key) }
+func (p Persistent[K, V]) Rank(key K) int                 { return p.view().Rank(key) }
+func (p Persistent[K, V]) Select(i int) (K, V, bool)      { return p.view().Select(i) }
+func (p Persistent[K, V]) All() iter.Seq2[K, V]           { return p.view().All() }
+func (p Persistent[K, V]) Range(lo, hi K) iter.Seq2[K, V] { return p.view().Range(lo, hi) }
+
+// Shared is a Persistent many goroutines use: the readers take a Snapshot,
+// an atomic load without any lock, and keep reading it as long as they want
+// while the writers publish new versions. The writers still take turns, two
+// Updates at the same time would lose one of them
+type Shared[K, V any] struct {
+	mu  sync.Mutex // only for writers
+	cur atomic.Pointer[Persistent[K, V]]
+}
+
+func NewShared[K, V any](cmp func(a, b K) int) *Shared[K, V] {
+	s := &Shared[K, V]{}
+	p := NewPersistent[K, V](cmp)
+	s.cur.Store(&p)
+	return s
+}
+
+func (s *Shared[K, V]) Snapshot() Persistent[K, V] {
+	return *s.cur.Load()
+}
+
+// Update replaces the tree with what fn returns, fn gets the current version
+func (s *Shared[K, V]) Update(fn func(Persistent[K, V]) Persistent[K, V]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := fn(*s.cur.Load())
+	s.cur.Store(&next)
+}
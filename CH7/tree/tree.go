@@ -0,0 +1,345 @@
+package main
+
+import (
+	"cmp"
+	"iter"
+)
+
+// Tree
+// IntTree in methods2.go, for any key and with a value, kept balanced. It's an
+// AVL tree: the heights of the two children of a node differ at most by one, so
+// every operation is O(log n) even when the keys are inserted in order, which
+// turns IntTree into a linked list. Every node also knows the size of its
+// subtree, that's what Rank and Select need
+//
+// The node methods still work with a nil receiver, like IntTree, and the ones
+// that change the tree return the new root of the subtree
+
+type Tree[K, V any] struct {
+	root *node[K, V]
+	cmp  func(a, b K) int // negative if a < b, 0 if equal, positive if a > b
+}
+
+// Set is a Tree without values
+type Set[K any] = Tree[K, struct{}]
+
+type node[K, V any] struct {
+	key         K
+	val         V
+	left, right *node[K, V]
+	height      int
+	size        int
+}
+
+// New returns an empty tree ordered by cmp
+func New[K, V any](cmp func(a, b K) int) *Tree[K, V] {
+	return &Tree[K, V]{cmp: cmp}
+}
+
+// NewOrdered is New with the natural order of K
+func NewOrdered[K cmp.Ordered, V any]() *Tree[K, V] {
+	return New[K, V](cmp.Compare[K])
+}
+
+func (n *node[K, V]) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *node[K, V]) getSize() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// update fixes height and size after a child changed
+func (n *node[K, V]) update() {
+	n.height = 1 + max(n.left.getHeight(), n.right.getHeight())
+	n.size = 1 + n.left.getSize() + n.right.getSize()
+}
+
+func (n *node[K, V]) balanceFactor() int {
+	return n.left.getHeight() - n.right.getHeight()
+}
+
+// rotateRight lifts the left child, the order of a, l, b, n, c doesn't change
+//
+//	    n             l
+//	   / \           / \
+//	  l   c   ->    a   n
+//	 / \               / \
+//	a   b             b   c
+func (n *node[K, V]) rotateRight() *node[K, V] {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func (n *node[K, V]) rotateLeft() *node[K, V] {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// rebalance is called on the way up after an insert or delete below n
+func (n *node[K, V]) rebalance() *node[K, V] {
+	n.update()
+	switch bf := n.balanceFactor(); {
+	case bf > 1:
+		if n.left.balanceFactor() < 0 { // left-right case
+			n.left = n.left.rotateLeft()
+		}
+		return n.rotateRight()
+	case bf < -1:
+		if n.right.balanceFactor() > 0 { // right-left case
+			n.right = n.right.rotateRight()
+		}
+		return n.rotateLeft()
+	}
+	return n
+}
+
+func (t *Tree[K, V]) insert(n *node[K, V], key K, val V) (*node[K, V], bool) {
+	if n == nil {
+		return &node[K, V]{key: key, val: val, height: 1, size: 1}, true
+	}
+	var added bool
+	switch c := t.cmp(key, n.key); {
+	case c < 0:
+		n.left, added = t.insert(n.left, key, val)
+	case c > 0:
+		n.right, added = t.insert(n.right, key, val)
+	default:
+		n.val = val
+		return n, false
+	}
+	return n.rebalance(), added
+}
+
+// Put sets the value of key, it reports if the key is new
+func (t *Tree[K, V]) Put(key K, val V) bool {
+	var added bool
+	t.root, added = t.insert(t.root, key, val)
+	return added
+}
+
+// deleteMin removes the smallest node of the subtree and returns it too
+func (n *node[K, V]) deleteMin() (root, smallest *node[K, V]) {
+	if n.left == nil {
+		return n.right, n
+	}
+	n.left, smallest = n.left.deleteMin()
+	return n.rebalance(), smallest
+}
+
+func (t *Tree[K, V]) delete(n *node[K, V], key K) (*node[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+	var deleted bool
+	switch c := t.cmp(key, n.key); {
+	case c < 0:
+		n.left, deleted = t.delete(n.left, key)
+	case c > 0:
+		n.right, deleted = t.delete(n.right, key)
+	default:
+		if n.left == nil {
+			return n.right, true
+		}
+		if n.right == nil {
+			return n.left, true
+		}
+		// two children, the successor takes the place of n
+		right, succ := n.right.deleteMin()
+		succ.left, succ.right = n.left, right
+		return succ.rebalance(), true
+	}
+	return n.rebalance(), deleted
+}
+
+// Delete removes key, it reports if it was there
+func (t *Tree[K, V]) Delete(key K) bool {
+	var deleted bool
+	t.root, deleted = t.delete(t.root, key)
+	return deleted
+}
+
+func (t *Tree[K, V]) find(key K) *node[K, V] {
+	n := t.root
+	for n != nil {
+		switch c := t.cmp(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *Tree[K, V]) Get(key K) (V, bool) {
+	if n := t.find(key); n != nil {
+		return n.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (t *Tree[K, V]) Contains(key K) bool {
+	return t.find(key) != nil
+}
+
+func (t *Tree[K, V]) Len() int {
+	return t.root.getSize()
+}
+
+// result turns a node in the (key, value, found) that the queries return
+func (n *node[K, V]) result() (K, V, bool) {
+	if n == nil {
+		var k K
+		var v V
+		return k, v, false
+	}
+	return n.key, n.val, true
+}
+
+func (t *Tree[K, V]) Min() (K, V, bool) {
+	n := t.root
+	for n != nil && n.left != nil {
+		n = n.left
+	}
+	return n.result()
+}
+
+func (t *Tree[K, V]) Max() (K, V, bool) {
+	n := t.root
+	for n != nil && n.right != nil {
+		n = n.right
+	}
+	return n.result()
+}
+
+// Floor is the greatest key <= key
+func (t *Tree[K, V]) Floor(key K) (K, V, bool) {
+	var best *node[K, V]
+	for n := t.root; n != nil; {
+		c := t.cmp(key, n.key)
+		if c == 0 {
+			return n.result()
+		}
+		if c < 0 {
+			n = n.left
+		} else {
+			best, n = n, n.right
+		}
+	}
+	return best.result()
+}
+
+// Ceiling is the smallest key >= key
+func (t *Tree[K, V]) Ceiling(key K) (K, V, bool) {
+	var best *node[K, V]
+	for n := t.root; n != nil; {
+		c := t.cmp(key, n.key)
+		if c == 0 {
+			return n.result()
+		}
+		if c > 0 {
+			n = n.right
+		} else {
+			best, n = n, n.left
+		}
+	}
+	return best.result()
+}
+
+// Rank is how many keys are smaller than key, it's also the index key has, or
+// would have, in the sorted keys
+func (t *Tree[K, V]) Rank(key K) int {
+	rank := 0
+	for n := t.root; n != nil; {
+		c := t.cmp(key, n.key)
+		switch {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			rank += n.left.getSize() + 1
+			n = n.right
+		default:
+			return rank + n.left.getSize()
+		}
+	}
+	return rank
+}
+
+// Select is the key at index i of the sorted keys, the opposite of Rank
+func (t *Tree[K, V]) Select(i int) (K, V, bool) {
+	if i < 0 || i >= t.Len() {
+		return (*node[K, V])(nil).result()
+	}
+	n := t.root
+	for {
+		left := n.left.getSize()
+		switch {
+		case i < left:
+			n = n.left
+		case i > left:
+			i -= left + 1
+			n = n.right
+		default:
+			return n.result()
+		}
+	}
+}
+
+// All iterates the keys in order
+func (t *Tree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.root.walk(yield)
+	}
+}
+
+// walk returns false when yield asked to stop
+func (n *node[K, V]) walk(yield func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.walk(yield) && yield(n.key, n.val) && n.right.walk(yield)
+}
+
+// Range iterates the keys in [lo, hi) in order, skipping the subtrees that
+// are out of the range
+func (t *Tree[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		t.walkRange(t.root, lo, hi, yield)
+	}
+}
+
+func (t *Tree[K, V]) walkRange(n *node[K, V], lo, hi K, yield func(K, V) bool) bool {
+	if n == nil {
+		return true
+	}
+	aboveLo := t.cmp(n.key, lo) >= 0
+	belowHi := t.cmp(n.key, hi) < 0
+	if aboveLo && !t.walkRange(n.left, lo, hi, yield) {
+		return false
+	}
+	if aboveLo && belowHi && !yield(n.key, n.val) {
+		return false
+	}
+	if belowHi {
+		return t.walkRange(n.right, lo, hi, yield)
+	}
+	return true
+}
@@ -49,7 +49,7 @@ func LoginAndGetData(uid, pwd, file string) ([]byte, error) {
 		return nil, StatusErr{
 			Status: InvalidLogin,
 			Message: fmt.Sprintf("invalid credentials for user %s", uid)
-			Err: err,
+			err: err,
 		}
 	}
 	data, err := getData(file)
@@ -57,7 +57,7 @@ func LoginAndGetData(uid, pwd, file string) ([]byte, error) {
 		return nil, StatusErr{
 			Status: NotFound,
 			Message: fmt.Sprintf("file %s not found", file),
-			Err: err,
+			err: err,
 		}
 	}
 	return data, nil
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	errs ".."
+)
+
+// errs is a package of its own now, the real Controller of CH7/interfaces4.go
+// imports it too. There is no go.mod in this tree, so the import path is
+// relative and it runs in GOPATH mode:
+//
+//	GO111MODULE=off go run .
+//
+// This handler is a smaller one than the Controller, with a failure of every
+// kind. The logic says what kind of failure it was, the handler doesn't need to
+// know which status goes with each one
+
+type DataStore interface {
+	UserNameForID(userID string) (string, error)
+}
+
+type SimpleDataStore struct {
+	userData map[string]string
+	down     bool // to see what an outage looks like
+}
+
+func (sds SimpleDataStore) UserNameForID(userID string) (string, error) {
+	if sds.down {
+		// the cause is for the log, the client only learns it can retry
+		return "", errs.Wrap(errors.New("dial tcp 10.0.0.7:5432: connection refused"), errs.Unavailable, "the user store is unavailable, try again later")
+	}
+	name, ok := sds.userData[userID]
+	if !ok {
+		return "", errs.New(errs.NotFound, "user %s not found", userID)
+	}
+	return name, nil
+}
+
+type SimpleLogic struct {
+	ds DataStore
+}
+
+func (sl SimpleLogic) SayHello(userID, token string) (string, error) {
+	if token == "" {
+		return "", errs.New(errs.Unauthenticated, "missing token")
+	}
+	if token != "secret" {
+		return "", errs.New(errs.PermissionDenied, "the token can't read users")
+	}
+	if userID == "" {
+		return "", errs.New(errs.InvalidArgument, "user_id is required")
+	}
+	if strings.HasPrefix(userID, "loop") {
+		// not one of ours, it ends up as Internal and the client doesn't see it
+		return "", fmt.Errorf("in SayHello: template %q: unexpected EOF", "hello.tmpl")
+	}
+	name, err := sl.ds.UserNameForID(userID)
+	if err != nil {
+		return "", fmt.Errorf("in SayHello: %w", err)
+	}
+	return "Hello, " + name, nil
+}
+
+type Controller struct {
+	logic SimpleLogic
+}
+
+func (c Controller) SayHello(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	message, err := c.logic.SayHello(userID, r.Header.Get("X-Token"))
+	if err != nil {
+		errs.WriteError(w, r, err)
+		return
+	}
+	w.Write([]byte(message))
+}
+
+// LoginAndGetData of errors3.go, with Err spelled err there the wrapping works.
+// Only a missing file is a 404, the kind comes from what the os error says
+func getData(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		return data, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, errs.Wrap(err, errs.NotFound, "file %s not found", file)
+	case errors.Is(err, fs.ErrPermission):
+		return nil, errs.Wrap(err, errs.PermissionDenied, "file %s can't be read", file)
+	default:
+		return nil, errs.Wrap(err, errs.Internal, "")
+	}
+}
+
+func main() {
+	up := Controller{SimpleLogic{SimpleDataStore{userData: map[string]string{"1": "Fred", "2": "Mary"}}}}
+	down := Controller{SimpleLogic{SimpleDataStore{down: true}}}
+	for _, req := range []struct {
+		c      Controller
+		target string
+		token  string
+	}{
+		{up, "/hello?user_id=1", "secret"},
+		{up, "/hello?user_id=3", "secret"},
+		{up, "/hello", "secret"},
+		{up, "/hello?user_id=1", ""},
+		{up, "/hello?user_id=1", "guest"},
+		{up, "/hello?user_id=loop", "secret"},
+		{down, "/hello?user_id=1", "secret"},
+	} {
+		r := httptest.NewRequest("GET", req.target, nil)
+		if req.token != "" {
+			r.Header.Set("X-Token", req.token)
+		}
+		w := httptest.NewRecorder()
+		req.c.SayHello(w, r)
+		body, _ := io.ReadAll(w.Body)
+		fmt.Printf("%-22s %d %-24s %s\n", req.target, w.Code, w.Header().Get("Content-Type"), strings.TrimSpace(string(body)))
+	}
+
+	// The chain: our Error outside, the os error inside
+	_, err := getData("not_here.txt")
+	err = fmt.Errorf("in LoginAndGetData: %w", err)
+	var pathErr *fs.PathError
+	fmt.Println(err)
+	fmt.Println("not found kind:", errors.Is(err, errs.ErrNotFound), "conflict kind:", errors.Is(err, errs.ErrConflict))
+	fmt.Println("os.ErrNotExist:", errors.Is(err, os.ErrNotExist), "PathError:", errors.As(err, &pathErr))
+	fmt.Printf("status %d, the client reads %q\n", errs.KindOf(err).HTTPStatus(), errs.SafeMessage(err))
+
+	// a directory can't be read as a file, that is not a 404
+	_, err = getData(os.TempDir())
+	fmt.Printf("%v: status %d, the client reads %q\n", err, errs.KindOf(err).HTTPStatus(), errs.SafeMessage(err))
+
+	// errors.Is only finds an Error that is in the chain, a plain error has none,
+	// KindOf is the one that says it is served as Internal
+	err = errors.New("disk full")
+	fmt.Println("plain error, kind:", errs.KindOf(err), "is ErrInternal:", errors.Is(err, errs.ErrInternal))
+}
@@ -0,0 +1,126 @@
+// Package errs has the error kinds of a service and their HTTP mapping, for
+// the Controller of CH7/interfaces4.go and whatever else answers requests.
+// There is no go.mod in this tree, the importers use a relative path and run in
+// GOPATH mode (GO111MODULE=off), demo/main.go shows how
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds
+// StatusErr in errors2.go and errors3.go, with every kind of failure a service
+// needs and not only InvalidLogin and NotFound. An Error has two messages: the
+// safe one, Message, is for the client, "user 42 not found", and the wrapped
+// error with all the details, "open /data/users.db: permission denied", is for
+// the log, a client should never see a path or a SQL query
+
+type Kind int
+
+const (
+	Internal Kind = iota // the zero value, an Error without a kind is a bug on our side
+	NotFound
+	InvalidArgument
+	Unauthenticated
+	PermissionDenied
+	Conflict
+	Unavailable
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case InvalidArgument:
+		return "invalid argument"
+	case Unauthenticated:
+		return "unauthenticated"
+	case PermissionDenied:
+		return "permission denied"
+	case Conflict:
+		return "conflict"
+	case Unavailable:
+		return "unavailable"
+	default:
+		return "internal"
+	}
+}
+
+type Error struct {
+	Kind    Kind
+	Message string // safe to show to the client
+	Err     error  // what went wrong inside, only for the log
+}
+
+// New is an Error that doesn't wrap anything, the message is also the safe one
+func New(kind Kind, format string, args ...any) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap puts err inside an Error of kind, with a safe message. Like in
+// errors3.go the wrapped error stays reachable with errors.Is and errors.As.
+// Wrap of a nil error is nil, so it can wrap whatever a call returned
+func Wrap(err error, kind Kind, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// Error is the full message for the log, the safe part and the wrapped one
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	default:
+		return e.Message + ": " + e.Err.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// The kinds as sentinels, errors.Is(err, ErrNotFound) asks for the kind of
+// the Error in the chain, whatever its message. errors.Is only looks at what is
+// in the chain, so a plain error, with no Error in it, is not ErrInternal even
+// if KindOf says Internal: Is answers "did someone say Internal", KindOf
+// answers "how is it served". Use KindOf(err) == Internal for the second one
+var (
+	ErrInternal         = &Error{Kind: Internal}
+	ErrNotFound         = &Error{Kind: NotFound}
+	ErrInvalidArgument  = &Error{Kind: InvalidArgument}
+	ErrUnauthenticated  = &Error{Kind: Unauthenticated}
+	ErrPermissionDenied = &Error{Kind: PermissionDenied}
+	ErrConflict         = &Error{Kind: Conflict}
+	ErrUnavailable      = &Error{Kind: Unavailable}
+)
+
+// Is matches a target that only has a kind, like the sentinels above
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Message == "" && t.Err == nil && t.Kind == e.Kind
+}
+
+// KindOf is the kind of the first Error in the chain, an error that isn't
+// one of ours, a plain fmt.Errorf or an os error, is Internal
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
+
+// SafeMessage is what the client can be told. An Internal error only says
+// "internal error", even when it has a message, it may be about our internals
+func SafeMessage(err error) string {
+	var e *Error
+	if !errors.As(err, &e) || e.Kind == Internal || e.Message == "" {
+		return KindOf(err).String() + " error"
+	}
+	return e.Message
+}
@@ -0,0 +1,67 @@
+package errs
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HTTP mapping
+// Every kind has its status code, and the body is a problem+json document
+// (RFC 9457) so the clients can read the error without parsing text:
+//
+//	{"type":"about:blank","title":"Not Found","status":404,"detail":"user 42 not found","kind":"not found"}
+
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case NotFound:
+		return http.StatusNotFound
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case PermissionDenied:
+		return http.StatusForbidden
+	case Conflict:
+		return http.StatusConflict
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Kind     string `json:"kind"` // an extension member, the RFC allows them
+}
+
+// ProblemFor builds the body for err, only with the safe message
+func ProblemFor(err error, instance string) Problem {
+	kind := KindOf(err)
+	return Problem{
+		Type:     "about:blank", // the status says it all, no page documents the problem
+		Title:    http.StatusText(kind.HTTPStatus()),
+		Status:   kind.HTTPStatus(),
+		Detail:   SafeMessage(err),
+		Instance: instance,
+		Kind:     kind.String(),
+	}
+}
+
+// WriteError logs the whole error and answers with the problem, what the
+// handlers call instead of w.WriteHeader(http.StatusBadRequest) for everything
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	p := ProblemFor(err, r.URL.Path)
+	log.Printf("%s %s: %d %v", r.Method, r.URL.Path, p.Status, err)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Println("in WriteError:", err)
+	}
+}
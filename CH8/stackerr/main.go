@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+// DoSomething of errors5.go with the errors of stackerr.go
+
+func doThing1(val int) (int, error) {
+	if val < 0 {
+		return 0, New("negative value")
+	}
+	return val * 2, nil
+}
+
+func doThing2(val string) (string, error) {
+	f, err := os.Open(val)
+	if err != nil {
+		return "", Wrap(err, "in doThing2") // a standard library error, the stack starts here
+	}
+	defer f.Close()
+	return val, nil
+}
+
+func doThing3(val3 int, val4 string) (string, error) {
+	if val4 == "" {
+		return "", Errorf("in doThing3: empty name for %d", val3)
+	}
+	return fmt.Sprint(val4, val3), nil
+}
+
+func DoSomething(val1 int, val2 string) (_ string, err error) {
+	defer WrapDefer(&err, "in DoSomething")
+
+	val3, err := doThing1(val1)
+	if err != nil {
+		return "", err
+	}
+	val4, err := doThing2(val2)
+	if err != nil {
+		return "", err
+	}
+	return doThing3(val3, val4)
+}
+
+func main() {
+	_, err := DoSomething(1, "not_here.txt")
+	fmt.Printf("%%v: %v\n\n", err)
+	fmt.Printf("%%+v:\n%+v\n", err)
+
+	// still a normal chain for errors.Is and errors.As
+	var pathErr *fs.PathError
+	fmt.Println("is ErrNotExist:", errors.Is(err, os.ErrNotExist), "as PathError:", errors.As(err, &pathErr))
+
+	_, err = DoSomething(-1, "")
+	fmt.Printf("\n%%+v:\n%+v\n", err)
+	frames := Frames(err)
+	fmt.Printf("it started in %s at line %d\n\n", frames[0].Function, frames[0].Line)
+
+	benchmarks()
+}
+
+// Benchmarks, with testing.Benchmark because the repo doesn't have test
+// files. deep is how many calls there are between the benchmark and the
+// error, a real stack is deeper than the benchmark's
+
+var sink error
+
+func deep(n int, fn func() error) error {
+	if n == 0 {
+		return fn()
+	}
+	return deep(n-1, fn)
+}
+
+func benchmarks() {
+	base := errors.New("base")
+	withStack := New("base")
+	for _, bm := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"errors.New", func() error { return errors.New("boom") }},
+		{"New", func() error { return New("boom") }},
+		{"New, 20 calls deep", func() error { return deep(20, func() error { return New("boom") }) }},
+		{"fmt.Errorf %w", func() error { return fmt.Errorf("in X: %w", base) }},
+		{"Wrap, first stack", func() error { return Wrap(base, "in X") }},
+		{"Wrap over a stack", func() error { return Wrap(withStack, "in X") }},
+		{"Errorf over a stack", func() error { return Errorf("in X: %w", withStack) }},
+		{"Wrap, then %v", func() error { _ = fmt.Sprintf("%v", Wrap(withStack, "in X")); return nil }},
+		{"Wrap, then %+v", func() error { _ = fmt.Sprintf("%+v", Wrap(withStack, "in X")); return nil }},
+	} {
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sink = bm.fn()
+			}
+		})
+		fmt.Printf("%-22s %6d ns/op %5d B/op %3d allocs/op\n", bm.name, r.NsPerOp(), r.AllocedBytesPerOp(), r.AllocsPerOp())
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// Errors with stack traces
+// errors5.go ends with "it is best to wrap error with stack traces", Go doesn't
+// add them. These errors remember where they were created: New, Errorf and
+// Wrap record the stack of their caller. %v prints the message as always, the
+// same "in DoSomething: ..." a fmt.Errorf would give, %+v prints the chain, one
+// message per line with the file:line of each wrap, and the whole stack where
+// the error started
+//
+// The stack is expensive, runtime.Callers walks the goroutine's stack, so only
+// the first error of a chain records all the frames, a Wrap over an error that
+// already has a stack records just one, its own location
+
+const maxDepth = 32
+
+type stackError struct {
+	msg   string
+	err   error     // wrapped, nil for New
+	pcs   []uintptr // the stack, or only the caller when the chain has one already
+	wraps bool      // true when err is part of the message, "msg: err"
+}
+
+func (e *stackError) Error() string {
+	switch {
+	case !e.wraps:
+		return e.msg
+	case e.msg == "":
+		return e.err.Error()
+	default:
+		return e.msg + ": " + e.err.Error()
+	}
+}
+
+func (e *stackError) Unwrap() error {
+	return e.err
+}
+
+// callers records the stack above the function that called callers' caller,
+// skip 3 leaves out runtime.Callers, callers and New/Wrap/Errorf
+func callers(skip int, full bool) []uintptr {
+	depth := maxDepth
+	if !full {
+		depth = 1
+	}
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(skip, pcs)
+	return pcs[:n]
+}
+
+// hasStack reports if some error in the chain already recorded a full stack
+func hasStack(err error) bool {
+	var se *stackError
+	return errors.As(err, &se)
+}
+
+// New is errors.New with the stack
+func New(msg string) error {
+	return &stackError{msg: msg, pcs: callers(3, true)}
+}
+
+// Errorf is fmt.Errorf with the stack, %w works like there
+func Errorf(format string, args ...any) error {
+	// the fmt error is kept inside, it knows how to unwrap, even several %w
+	err := fmt.Errorf(format, args...)
+	return &stackError{err: err, wraps: true, pcs: callers(3, !hasStack(err))}
+}
+
+// Wrap is fmt.Errorf("msg: %w", err) that also records where it happened, a nil
+// err is nil, like in the defer of errors5.go the wrap can be unconditional
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
+	return &stackError{msg: msg, err: err, wraps: true, pcs: callers(3, !hasStack(err))}
+}
+
+// WrapDefer is the deferred wrap of errors5.go:
+//
+//	func DoSomething() (err error) {
+//		defer WrapDefer(&err, "in DoSomething")
+//
+// the location is the line of DoSomething that returned
+func WrapDefer(errp *error, msg string) {
+	if *errp != nil {
+		*errp = &stackError{msg: msg, err: *errp, wraps: true, pcs: callers(3, !hasStack(*errp))}
+	}
+}
+
+// Frames is the stack of the first error in the chain that has one, the
+// deepest one, where the error started
+func Frames(err error) []runtime.Frame {
+	var deepest *stackError
+	for err != nil {
+		if se, ok := err.(*stackError); ok && len(se.pcs) > 0 {
+			deepest = se
+		}
+		// through a fork, the first branch
+		inners := unwrapAll(err)
+		err = nil
+		if len(inners) > 0 {
+			err = inners[0]
+		}
+	}
+	if deepest == nil {
+		return nil
+	}
+	var frames []runtime.Frame
+	iter := runtime.CallersFrames(deepest.pcs)
+	for {
+		f, more := iter.Next()
+		frames = append(frames, f)
+		if !more {
+			return frames
+		}
+	}
+}
+
+// Format is called by fmt, %v, %s and %q are the usual message, %+v the chain
+func (e *stackError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		e.writeChain(s)
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	default:
+		io.WriteString(s, e.Error())
+	}
+}
+
+// writeChain writes every error of the chain from the outside in, with only
+// its own part of the message
+//
+//	in DoSomething
+//	    main.DoSomething
+//	        /src/main.go:20
+//	doThing2 failed
+//	    main.doThing2
+//	        /src/main.go:12
+//	    main.DoSomething
+//	        /src/main.go:18
+//	    ...
+//
+// An error that wraps several, errors.Join or an Errorf with more than one %w,
+// is a fork: its whole message, then each branch indented under it
+func (e *stackError) writeChain(w io.Writer) {
+	writeChain(w, e, "")
+}
+
+func writeChain(w io.Writer, err error, indent string) {
+	for err != nil {
+		layer := err
+		var pcs []uintptr
+		if se, ok := err.(*stackError); ok {
+			pcs = se.pcs
+			if se.msg == "" && se.wraps {
+				// from Errorf, its message is the one of the fmt error inside
+				layer = se.err
+			}
+		}
+		inners := unwrapAll(layer)
+		msg := layer.Error()
+		if len(inners) == 1 {
+			// "outer: inner" -> "outer", whoever made the message
+			msg = strings.TrimSuffix(strings.TrimSuffix(msg, inners[0].Error()), ": ")
+		}
+		if msg != "" {
+			fmt.Fprintf(w, "%s%s\n", indent, msg)
+		}
+		writeFrames(w, pcs, indent)
+		if len(inners) > 1 {
+			for i, inner := range inners {
+				fmt.Fprintf(w, "%s  wrapped %d of %d:\n", indent, i+1, len(inners))
+				writeChain(w, inner, indent+"    ")
+			}
+			return
+		}
+		err = nil
+		if len(inners) == 1 {
+			err = inners[0]
+		}
+	}
+}
+
+// unwrapAll is errors.Unwrap that also knows Unwrap() []error, the errors.Unwrap
+// of those is nil and the chain would stop there
+func unwrapAll(err error) []error {
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := u.Unwrap(); inner != nil {
+			return []error{inner}
+		}
+	case interface{ Unwrap() []error }:
+		return u.Unwrap()
+	}
+	return nil
+}
+
+func writeFrames(w io.Writer, pcs []uintptr, indent string) {
+	if len(pcs) == 0 {
+		return
+	}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			fmt.Fprintf(w, "%s    %s\n%s        %s:%d\n", indent, f.Function, indent, f.File, f.Line)
+		}
+		if !more {
+			return
+		}
+	}
+}